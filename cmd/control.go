@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"git-fs/internal/config"
+	"git-fs/internal/control"
+	"git-fs/internal/logging"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var controlDescriptions = map[string]string{
+	control.ActionPause:    "Pause processing; changes keep accumulating until resumed",
+	control.ActionResume:   "Resume processing and handle the changes accumulated while paused",
+	control.ActionSync:     "Process pending changes and push right away",
+	control.ActionFlush:    "Process pending changes without waiting for the debounce",
+	control.ActionReload:   "Reload the daemon configuration",
+	control.ActionSnapshot: "Scan the whole watch path and commit everything that changed",
+	control.ActionGC:       "Repack encrypted objects and remove unused ones",
+}
+
+// controlClient returns a client for the control socket of the daemon serving cfg.
+func controlClient(cfg *config.Config) *control.Client {
+	return control.NewClient(filepath.Join(cfg.RepoPath, control.SocketName))
+}
+
+func newControlCmd(action string) *cobra.Command {
+	return &cobra.Command{
+		Use:   action,
+		Short: controlDescriptions[action],
+		Long:  controlDescriptions[action] + `, through the control socket of the running daemon.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger := logging.Logger
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				logger.Error("Failed to load config", zap.Error(err))
+				cmd.PrintErrln("Error: Could not load configuration.")
+				return
+			}
+
+			// Sync and flush wait for a whole batch, which may take a while.
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			defer cancel()
+
+			if err := controlClient(cfg).Do(ctx, action); err != nil {
+				logger.Error("Control request failed", zap.String("action", action), zap.Error(err))
+				cmd.PrintErrf("Error: Could not %s the daemon: %v\n", action, err)
+				return
+			}
+			cmd.Println("Done.")
+		},
+	}
+}
+
+func init() {
+	for _, action := range control.Actions {
+		daemonCmd.AddCommand(newControlCmd(action))
+	}
+}
@@ -1,18 +1,38 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
 	"git-fs/internal/config"
 	"git-fs/internal/daemon"
 	"git-fs/internal/logging"
+	"git-fs/internal/process"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
 
+var (
+	daemonDetach      bool
+	daemonPIDFile     string
+	daemonLogFile     string
+	daemonStopTimeout time.Duration
+)
+
 var daemonCmd = &cobra.Command{
 	Use:   "daemon",
 	Short: "Start the watcher daemon",
-	Long:  `Runs in the background, watching the directory for changes and encrypting & committing them.`,
+	Long: `Runs in the background, watching the directory for changes and encrypting & committing them.
+
+By default the daemon runs in the foreground; --detach starts it in the background with its output
+appended to the log file. SIGINT and SIGTERM flush pending changes and stop the daemon cleanly;
+SIGHUP reloads the configuration.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		logger := logging.Logger
 
@@ -23,9 +43,55 @@ var daemonCmd = &cobra.Command{
 			return
 		}
 
+		if daemonDetach {
+			startDetached(cmd, cfg)
+			return
+		}
+
+		// Handle signals before anything else, so that a stop during startup
+		// still releases the lock and pidfile.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		repoLock, ok := lockRepository(cmd, cfg)
+		if !ok {
+			return
+		}
+		defer repoLock.Release()
+
 		logger.Info("Starting daemon", zap.String("repo", cfg.RepoPath), zap.String("watch", cfg.WatchPath))
 
-		if err := daemon.RunDaemon(cfg); err != nil {
+		d, err := daemon.New(cfg)
+		if err != nil {
+			logger.Error("Failed to start daemon", zap.Error(err))
+			cmd.Println("Error: Daemon failed to start. Check logs for details.")
+			return
+		}
+
+		// The pidfile tells daemon --detach that startup succeeded, so it is
+		// only written once the daemon is set up.
+		pidPath, _ := daemonPaths(cfg)
+		if err := process.WritePIDFile(pidPath); err != nil {
+			logger.Warn("Failed to write pidfile", zap.String("path", pidPath), zap.Error(err))
+		}
+		defer process.RemovePIDFile(pidPath)
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-hup:
+					logger.Info("Received SIGHUP, reloading configuration")
+					d.Reload()
+				}
+			}
+		}()
+
+		if err := d.Run(ctx); err != nil {
 			logger.Error("Failed to run daemon", zap.Error(err))
 			cmd.Println("Error: Daemon failed to start. Check logs for details.")
 			return
@@ -33,6 +99,157 @@ var daemonCmd = &cobra.Command{
 	},
 }
 
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the running daemon",
+	Long:  `Sends SIGTERM to the daemon recorded in the pidfile and waits for it to flush pending changes and exit.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			logging.Logger.Error("Failed to load config", zap.Error(err))
+			cmd.PrintErrln("Error: Could not load configuration.")
+			return
+		}
+
+		stopDaemon(cmd, cfg)
+	},
+}
+
+var daemonRestartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restart the daemon in the background",
+	Long:  `Stops the running daemon, if any, and starts a new one with --detach.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			logging.Logger.Error("Failed to load config", zap.Error(err))
+			cmd.PrintErrln("Error: Could not load configuration.")
+			return
+		}
+
+		if stopDaemon(cmd, cfg) {
+			startDetached(cmd, cfg)
+		}
+	},
+}
+
+// daemonPaths returns the absolute pidfile and log file paths for cfg.
+func daemonPaths(cfg *config.Config) (string, string) {
+	pidPath := daemonPIDFile
+	if pidPath == "" {
+		pidPath = filepath.Join(cfg.RepoPath, daemon.PIDFileName)
+	}
+	logPath := daemonLogFile
+	if logPath == "" {
+		logPath = filepath.Join(cfg.RepoPath, daemon.LogFileName)
+	}
+
+	if abs, err := filepath.Abs(pidPath); err == nil {
+		pidPath = abs
+	}
+	if abs, err := filepath.Abs(logPath); err == nil {
+		logPath = abs
+	}
+	return pidPath, logPath
+}
+
+// startDetached re-executes git-fs as a background daemon and waits until it
+// has written its pidfile.
+func startDetached(cmd *cobra.Command, cfg *config.Config) bool {
+	logger := logging.Logger
+	pidPath, logPath := daemonPaths(cfg)
+
+	if pid, err := process.ReadPIDFile(pidPath); err == nil {
+		cmd.PrintErrf("Error: Daemon is already running (pid %d).\n", pid)
+		return false
+	}
+
+	var args []string
+	if cfgFile != "" {
+		abs, err := filepath.Abs(cfgFile)
+		if err != nil {
+			abs = cfgFile
+		}
+		args = append(args, "--config", abs)
+	}
+	args = append(args, "daemon", "--pidfile", pidPath, "--logfile", logPath)
+
+	child, err := process.Detach(args, logPath)
+	if err != nil {
+		logger.Error("Failed to start detached daemon", zap.Error(err))
+		cmd.PrintErrln("Error: Could not start the daemon in the background.")
+		return false
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- child.Wait() }()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		if pid, err := process.ReadPIDFile(pidPath); err == nil && pid == child.Process.Pid {
+			logger.Info("Daemon started", zap.Int("pid", pid), zap.String("log", logPath))
+			cmd.Printf("Daemon started (pid %d), logging to %s\n", pid, logPath)
+			return true
+		}
+
+		select {
+		case err := <-exited:
+			logger.Error("Detached daemon exited during startup", zap.Error(err))
+			cmd.PrintErrf("Error: Daemon exited during startup. See %s for details.\n", logPath)
+			return false
+		case <-timeout:
+			cmd.PrintErrf("Error: Daemon did not report startup in time. See %s for details.\n", logPath)
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// stopDaemon terminates the daemon named in the pidfile and waits for it to
+// exit. It reports whether no daemon is running afterwards.
+func stopDaemon(cmd *cobra.Command, cfg *config.Config) bool {
+	logger := logging.Logger
+	pidPath, _ := daemonPaths(cfg)
+
+	pid, err := process.ReadPIDFile(pidPath)
+	if errors.Is(err, process.ErrNotRunning) {
+		cmd.Println("Daemon is not running.")
+		return true
+	}
+	if err != nil {
+		logger.Error("Failed to read pidfile", zap.String("path", pidPath), zap.Error(err))
+		cmd.PrintErrln("Error: Could not read the daemon pidfile.")
+		return false
+	}
+
+	if err := process.Terminate(pid); err != nil {
+		logger.Error("Failed to signal daemon", zap.Int("pid", pid), zap.Error(err))
+		cmd.PrintErrf("Error: Could not stop the daemon (pid %d).\n", pid)
+		return false
+	}
+
+	deadline := time.Now().Add(daemonStopTimeout)
+	for process.Alive(pid) {
+		if time.Now().After(deadline) {
+			cmd.PrintErrf("Error: Daemon (pid %d) did not stop within %s.\n", pid, daemonStopTimeout)
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	logger.Info("Daemon stopped", zap.Int("pid", pid))
+	cmd.Printf("Daemon stopped (pid %d).\n", pid)
+	return true
+}
+
 func init() {
+	daemonCmd.Flags().BoolVar(&daemonDetach, "detach", false, "run the daemon in the background")
+	daemonCmd.PersistentFlags().StringVar(&daemonPIDFile, "pidfile", "", "pidfile path (default: <repo_path>/"+daemon.PIDFileName+")")
+	daemonCmd.PersistentFlags().StringVar(&daemonLogFile, "logfile", "", "log file of a detached daemon (default: <repo_path>/"+daemon.LogFileName+")")
+	for _, c := range []*cobra.Command{daemonStopCmd, daemonRestartCmd} {
+		c.Flags().DurationVar(&daemonStopTimeout, "timeout", 60*time.Second, "how long to wait for the daemon to exit")
+	}
+
+	daemonCmd.AddCommand(daemonStopCmd, daemonRestartCmd)
 	rootCmd.AddCommand(daemonCmd)
 }
@@ -1,96 +1,197 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"git-fs/internal/config"
 	"git-fs/internal/crypto"
+	"git-fs/internal/fileattr"
 	filemetadata "git-fs/internal/filemetadata"
 	fileutils "git-fs/internal/fileutil"
 	"git-fs/internal/logging"
+	"git-fs/internal/restore"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
 
+var (
+	decryptConcurrency int
+	decryptResume      bool
+	decryptOwner       bool
+	decryptNoOwner     bool
+)
+
+// errDecryptFailed makes decrypt exit with a non-zero status once it has
+// told the user what went wrong.
+var errDecryptFailed = errors.New("decryption failed")
+
+// decryptProgressInterval is how often decrypt reports its progress.
+const decryptProgressInterval = 2 * time.Second
+
 var decryptCmd = &cobra.Command{
 	Use:   "decrypt",
 	Short: "Decrypt the encrypted files in the repository",
-	Long:  `Takes the files from the .encrypted directory and decrypts them to their original form.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Takes the files from the .encrypted directory and decrypts them to their original form.
+
+Files are decrypted concurrently and written atomically. An interrupted run can be continued with
+--resume, which skips files whose content already matches what is stored. The command exits with a
+non-zero status if any file could not be decrypted.
+
+Permissions, modification times and extended attributes are restored along with the content. Owners
+recorded with preserve_ownership are restored when running as root; --owner and --no-owner override
+that.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := logging.Logger
 
 		cfg, err := config.LoadConfig()
 		if err != nil {
 			logger.Error("Error loading config", zap.Error(err))
 			cmd.PrintErrln("Error: Could not load configuration. Please ensure config.yaml or ENV variables are set.")
-			return
+			return errDecryptFailed
+		}
+
+		repoLock, ok := lockRepository(cmd, cfg)
+		if !ok {
+			return errDecryptFailed
 		}
+		defer repoLock.Release()
 
 		saltPath := filepath.Join(cfg.RepoPath, ".salt")
 		salt, err := fileutils.SafeReadFile(saltPath)
 		if err != nil {
 			logger.Error("Failed to read salt", zap.String("path", saltPath), zap.Error(err))
 			cmd.PrintErrln("Error: Failed to read the salt file. Ensure the repository is initialized and the .salt file is present.")
-			return
+			return errDecryptFailed
 		}
 
 		key, err := crypto.DeriveKey(cfg.Password, salt)
 		if err != nil {
 			logger.Error("Error deriving key", zap.Error(err))
 			cmd.PrintErrln("Error: Unable to derive encryption key. Check your password and try again.")
-			return
+			return errDecryptFailed
+		}
+
+		keys, err := crypto.DeriveKeys(key)
+		if err != nil {
+			logger.Error("Error deriving subkeys", zap.Error(err))
+			cmd.PrintErrln("Error: Unable to derive encryption key. Check your password and try again.")
+			return errDecryptFailed
 		}
 
 		// Load metadata store
 		metadataPath := filepath.Join(cfg.RepoPath, ".metadata.enc")
-		metadataStore, err := filemetadata.LoadMetadataStore(metadataPath, key)
+		metadataStore, err := filemetadata.LoadMetadataStore(metadataPath, keys)
 		if err != nil {
 			logger.Error("Failed to load metadata store", zap.Error(err))
 			cmd.PrintErrln("Error: Could not load metadata store. Ensure the file exists and the password is correct.")
-			return
+			return errDecryptFailed
 		}
 
-		encryptedRoot := filepath.Join(cfg.RepoPath, ".encrypted")
+		counterPath := filepath.Join(cfg.RepoPath, filemetadata.CounterFileName)
+		if err := filemetadata.RecordCounter(counterPath, metadataStore.Counter); err != nil {
+			logger.Error("Metadata store failed the rollback check", zap.Error(err))
+			cmd.PrintErrf("Error: %v. If an older version was restored on purpose, remove %s.\n", err, counterPath)
+			return errDecryptFailed
+		}
 
-		// Process each file in the metadata store
-		for encryptedName, metadata := range metadataStore.Metadata {
-			encryptedPath := filepath.Join(encryptedRoot, encryptedName)
+		concurrency := cfg.Concurrency
+		if cmd.Flags().Changed("concurrency") {
+			concurrency = decryptConcurrency
+		}
+		restorer := restore.New(metadataStore, restore.Options{
+			Keys:        keys,
+			ObjectDir:   filepath.Join(cfg.RepoPath, ".encrypted"),
+			OutputDir:   cfg.WatchPath,
+			Concurrency: concurrency,
+			Resume:      decryptResume,
+			Owner:       restoreOwners(cmd),
+		})
 
-			// Skip if encrypted file doesn't exist
-			if !fileutils.FileExists(encryptedPath) {
-				logger.Warn("Encrypted file not found",
-					zap.String("encrypted_path", encryptedPath))
-				continue
-			}
+		// Stop handing out files on interrupt; those in progress finish.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-			// Create the output directory structure
-			outputPath := filepath.Join(cfg.WatchPath, metadata.OriginalPath)
-			if err := fileutils.EnsureDir(filepath.Dir(outputPath)); err != nil {
-				logger.Error("Failed to create directory",
-					zap.String("path", filepath.Dir(outputPath)),
-					zap.Error(err))
-				continue
+		done := make(chan *restore.Result)
+		go func() { done <- restorer.Run(ctx) }()
+		ticker := time.NewTicker(decryptProgressInterval)
+		defer ticker.Stop()
+		var result *restore.Result
+		for result == nil {
+			select {
+			case result = <-done:
+			case <-ticker.C:
+				printDecryptProgress(cmd, restorer.Progress())
 			}
+		}
 
-			// Decrypt the file
-			if err := crypto.DecryptFile(key, encryptedPath, outputPath); err != nil {
-				logger.Error("Failed to decrypt file",
-					zap.String("encrypted_file", encryptedPath),
-					zap.String("output_path", outputPath),
-					zap.Error(err))
-				continue
-			}
+		logger.Info("Decryption complete",
+			zap.String("watch_path", cfg.WatchPath),
+			zap.Int("files", result.Files),
+			zap.Int("skipped", result.Skipped),
+			zap.Int("failed", result.Failed))
 
-			logger.Info("File decrypted",
-				zap.String("encrypted_file", encryptedPath),
-				zap.String("decrypted_file", outputPath))
+		restored := result.Files - result.Skipped - result.Failed
+		if result.Skipped > 0 {
+			cmd.Printf("Decrypted %d files, skipped %d already restored.\n", restored, result.Skipped)
+		} else {
+			cmd.Printf("Decrypted %d files.\n", restored)
+		}
+		if len(result.Failures) > 0 {
+			cmd.PrintErrf("Error: %d files could not be decrypted:\n", len(result.Failures))
+			ownerFailed := false
+			for _, f := range result.Failures {
+				cmd.PrintErrf("  %s: %v\n", f.Path, f.Err)
+				ownerFailed = ownerFailed || errors.Is(f.Err, fileattr.ErrOwner)
+			}
+			if ownerFailed {
+				cmd.PrintErrln("Restoring file owners takes root; run decrypt as root, or without --owner.")
+			}
+		}
+		if result.Interrupted {
+			cmd.PrintErrf("Interrupted with %d of %d files done; run decrypt --resume to continue.\n", result.Files, result.TotalFiles)
+		}
+		if len(result.Failures) > 0 || result.Interrupted {
+			return errDecryptFailed
 		}
-
-		logger.Info("Decryption complete", zap.String("watch_path", cfg.WatchPath))
 		cmd.Println("Decryption complete.")
+		return nil
 	},
 }
 
+// restoreOwners reports whether decrypt restores file owners: by default
+// only as root, who can.
+func restoreOwners(cmd *cobra.Command) bool {
+	switch {
+	case decryptNoOwner:
+		return false
+	case cmd.Flags().Changed("owner"):
+		return decryptOwner
+	default:
+		return os.Geteuid() == 0
+	}
+}
+
+func printDecryptProgress(cmd *cobra.Command, p restore.Progress) {
+	cmd.PrintErrf("Decrypting: %d/%d files, %s/%s", p.Files, p.TotalFiles, formatBytes(p.Bytes), formatBytes(p.TotalBytes))
+	if eta := p.ETA().Round(time.Second); eta > 0 {
+		cmd.PrintErrf(", about %s left", eta)
+	}
+	cmd.PrintErrln()
+}
+
 func init() {
+	decryptCmd.Flags().IntVar(&decryptConcurrency, "concurrency", 0, "files decrypted at once (default: the concurrency setting, or one per CPU)")
+	decryptCmd.Flags().BoolVar(&decryptResume, "resume", false, "skip files whose content already matches what is stored")
+	decryptCmd.Flags().BoolVar(&decryptOwner, "owner", false, "restore file owners (default: only as root)")
+	decryptCmd.Flags().BoolVar(&decryptNoOwner, "no-owner", false, "don't restore file owners, even as root")
+	decryptCmd.MarkFlagsMutuallyExclusive("owner", "no-owner")
 	rootCmd.AddCommand(decryptCmd)
 }
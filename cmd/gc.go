@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"git-fs/internal/config"
+	"git-fs/internal/control"
+	"git-fs/internal/daemon"
+	"git-fs/internal/logging"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Repack encrypted objects and remove unused ones",
+	Long: `Rewrites pack files that are mostly unused or small into fewer, fuller ones, packs small files stored on
+their own if the repository was initialized with --pack, removes objects no file refers to any more and
+commits the result. If a daemon is running for the repository, it collects instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := logging.Logger
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			logger.Error("Failed to load config", zap.Error(err))
+			cmd.PrintErrln("Error: Could not load configuration. Please ensure config.yaml or ENV variables are set.")
+			return
+		}
+
+		// A running daemon owns the repository; let it do the work.
+		client := controlClient(cfg)
+		probe, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err = client.Status(probe)
+		cancel()
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			defer cancel()
+			if err := client.Do(ctx, control.ActionGC); err != nil {
+				logger.Error("Garbage collection through the daemon failed", zap.Error(err))
+				cmd.PrintErrf("Error: Garbage collection failed: %v\n", err)
+				return
+			}
+			cmd.Println("Garbage collected by the running daemon.")
+			return
+		}
+
+		repoLock, ok := lockRepository(cmd, cfg)
+		if !ok {
+			return
+		}
+		defer repoLock.Release()
+
+		d, err := daemon.New(cfg)
+		if err != nil {
+			logger.Error("Failed to open repository", zap.Error(err))
+			cmd.PrintErrln("Error: " + err.Error())
+			return
+		}
+
+		if err := d.GC(); err != nil {
+			cmd.PrintErrln("Error: Garbage collection failed. Check logs for details.")
+			return
+		}
+		if err := d.Sync(); err != nil {
+			cmd.PrintErrln("Warning: Garbage collected but not pushed; the next run retries the push.")
+			return
+		}
+		cmd.Println("Garbage collected.")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+}
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"path/filepath"
+	"strings"
+
 	"git-fs/internal/config"
 	"git-fs/internal/crypto"
 	fileutils "git-fs/internal/fileutil"
@@ -13,7 +16,12 @@ import (
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize the repository and encryption",
-	Long:  `Sets up the repository, generates a salt, and derives an encryption key.`,
+	Long: `Sets up the repository, generates a salt, and derives an encryption key.
+
+Run it again with --cipher or --padding to switch an existing repository to
+another cipher or padding scheme. Files already encrypted keep theirs and stay
+readable. --pack bundles small files into pack files from then on; run gc to
+pack the files already there.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		logger := logging.Logger
 
@@ -24,6 +32,12 @@ var initCmd = &cobra.Command{
 			return
 		}
 
+		repoLock, ok := lockRepository(cmd, cfg)
+		if !ok {
+			return
+		}
+		defer repoLock.Release()
+
 		saltPath := cfg.RepoPath + "/.salt"
 		salt, err := fileutils.ReadOrCreateSalt(saltPath)
 		if err != nil {
@@ -38,11 +52,72 @@ var initCmd = &cobra.Command{
 			return
 		}
 
-		logger.Info("Repository initialized with encryption key", zap.String("repo_path", cfg.RepoPath))
+		settings, err := config.LoadRepoSettings(cfg.RepoPath)
+		if err != nil {
+			logger.Error("Failed to load repository settings", zap.Error(err))
+			cmd.PrintErrf("Error: Could not read %s: %v\n", config.RepoSettingsFileName, err)
+			return
+		}
+		if cmd.Flags().Changed("cipher") && initCipher != settings.Cipher {
+			if _, err := crypto.SuiteByName(initCipher); err != nil {
+				cmd.PrintErrf("Error: %v. Choose one of: %s\n", err, strings.Join(cipherNames(), ", "))
+				return
+			}
+			if fileutils.FileExists(filepath.Join(cfg.RepoPath, config.RepoSettingsFileName)) {
+				// Files already encrypted stay readable; only new ones use the new cipher.
+				cmd.Printf("Switching cipher from %s to %s for files encrypted from now on.\n", settings.Cipher, initCipher)
+			}
+			settings.Cipher = initCipher
+		}
+		if cmd.Flags().Changed("padding") && initPadding != settings.Padding {
+			if _, err := crypto.PaddingByName(initPadding); err != nil {
+				cmd.PrintErrf("Error: %v. Choose one of: %s\n", err, strings.Join(paddingNames(), ", "))
+				return
+			}
+			if fileutils.FileExists(filepath.Join(cfg.RepoPath, config.RepoSettingsFileName)) {
+				cmd.Printf("Switching padding from %s to %s for files encrypted from now on.\n", settings.Padding, initPadding)
+			}
+			settings.Padding = initPadding
+		}
+		if cmd.Flags().Changed("pack") {
+			settings.Pack = initPack
+		}
+		if err := settings.Save(cfg.RepoPath); err != nil {
+			logger.Error("Failed to save repository settings", zap.Error(err))
+			cmd.PrintErrf("Error: Could not write %s: %v\n", config.RepoSettingsFileName, err)
+			return
+		}
+
+		logger.Info("Repository initialized with encryption key", zap.String("repo_path", cfg.RepoPath), zap.String("cipher", settings.Cipher), zap.String("padding", settings.Padding), zap.Bool("pack", settings.Pack))
 		cmd.Println("Repository initialized with encryption key.")
 	},
 }
 
+var (
+	initCipher  string
+	initPadding string
+	initPack    bool
+)
+
+func cipherNames() []string {
+	var names []string
+	for _, suite := range crypto.Suites {
+		names = append(names, suite.Name)
+	}
+	return names
+}
+
+func paddingNames() []string {
+	var names []string
+	for _, padding := range crypto.Paddings {
+		names = append(names, padding.String())
+	}
+	return names
+}
+
 func init() {
 	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().StringVar(&initCipher, "cipher", crypto.AES256GCM.Name, "cipher for new files: "+strings.Join(cipherNames(), " or "))
+	initCmd.Flags().StringVar(&initPadding, "padding", crypto.PaddingNone.String(), "padding of new files, to hide their sizes: "+strings.Join(paddingNames(), ", "))
+	initCmd.Flags().BoolVar(&initPack, "pack", false, "bundle small files into pack files; --pack=false stores each file on its own")
 }
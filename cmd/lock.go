@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"git-fs/internal/config"
+	"git-fs/internal/lock"
+	"git-fs/internal/logging"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// lockRepository takes the repository lock for a mutating command and tells
+// the user who holds it if that fails. The caller releases the lock.
+func lockRepository(cmd *cobra.Command, cfg *config.Config) (*lock.Lock, bool) {
+	l, err := lock.Acquire(cfg.RepoPath, cmd.Name())
+	if err != nil {
+		logging.Logger.Error("Failed to lock repository", zap.String("repo", cfg.RepoPath), zap.Error(err))
+		cmd.PrintErrln("Error: " + err.Error())
+		return nil, false
+	}
+	return l, true
+}
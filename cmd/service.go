@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"git-fs/internal/config"
+	fileutils "git-fs/internal/fileutil"
+	"git-fs/internal/logging"
+	"git-fs/internal/service"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var (
+	serviceName     string
+	serviceEnvFile  string
+	serviceWatchdog time.Duration
+	serviceEnable   bool
+	servicePrint    bool
+)
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage the git-fs service",
+	Long:  `Integrates the git-fs daemon with the system service manager.`,
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install a systemd user unit for the current configuration",
+	Long: `Generates a systemd user unit that runs the daemon with the current config file, using
+sd_notify for readiness and the systemd watchdog, and installs it under ~/.config/systemd/user.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := logging.Logger
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			logger.Error("Failed to load config", zap.Error(err))
+			cmd.PrintErrln("Error: Could not load configuration. Please ensure config.yaml or ENV variables are set.")
+			return
+		}
+
+		configFile := viper.ConfigFileUsed()
+		if configFile == "" {
+			cmd.PrintErrln("Error: The service needs a config file. Pass one with --config.")
+			return
+		}
+		configFile, err = filepath.Abs(configFile)
+		if err != nil {
+			logger.Error("Failed to resolve config path", zap.Error(err))
+			cmd.PrintErrln("Error: Could not resolve the config file path.")
+			return
+		}
+
+		exe, err := os.Executable()
+		if err == nil {
+			exe, err = filepath.EvalSymlinks(exe)
+		}
+		if err != nil {
+			logger.Error("Failed to resolve executable", zap.Error(err))
+			cmd.PrintErrln("Error: Could not determine the git-fs executable path.")
+			return
+		}
+
+		unit := &service.Unit{
+			Name:        serviceName,
+			Description: "git-fs encrypted backup of " + cfg.WatchPath,
+			ExecStart:   []string{exe, "--config", configFile, "daemon"},
+			// Relative paths in the config are resolved against its directory.
+			WorkingDir: filepath.Dir(configFile),
+			EnvFile:    serviceEnvFile,
+		}
+		if serviceWatchdog > 0 {
+			unit.Watchdog = fmt.Sprintf("%ds", int(serviceWatchdog.Seconds()))
+		}
+
+		data, err := unit.Render()
+		if err != nil {
+			logger.Error("Failed to render unit", zap.Error(err))
+			cmd.PrintErrln("Error: Could not generate the systemd unit.")
+			return
+		}
+		if servicePrint {
+			fmt.Fprint(cmd.OutOrStdout(), string(data))
+			return
+		}
+
+		unitPath, err := service.UserUnitPath(serviceName)
+		if err == nil {
+			err = fileutils.EnsureDir(filepath.Dir(unitPath))
+		}
+		if err == nil {
+			err = fileutils.WriteFileAtomic(unitPath, data, 0644)
+		}
+		if err != nil {
+			logger.Error("Failed to install unit", zap.String("path", unitPath), zap.Error(err))
+			cmd.PrintErrln("Error: Could not write the systemd unit.")
+			return
+		}
+		logger.Info("Installed systemd unit", zap.String("path", unitPath))
+		cmd.Printf("Installed %s\n", unitPath)
+
+		if err := systemctl("daemon-reload"); err != nil {
+			logger.Warn("systemctl daemon-reload failed", zap.Error(err))
+			cmd.PrintErrln("Warning: Could not reload systemd; run `systemctl --user daemon-reload` yourself.")
+			return
+		}
+
+		if !serviceEnable {
+			cmd.Printf("Start it with: systemctl --user enable --now %s\n", serviceName)
+			return
+		}
+		if err := systemctl("enable", "--now", serviceName); err != nil {
+			logger.Error("Failed to enable service", zap.Error(err))
+			cmd.PrintErrf("Error: Could not enable %s. Check `systemctl --user status %s`.\n", serviceName, serviceName)
+			return
+		}
+		cmd.Printf("Service %s enabled and started.\n", serviceName)
+	},
+}
+
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
+
+func init() {
+	serviceInstallCmd.Flags().StringVar(&serviceName, "name", "git-fs", "unit name")
+	serviceInstallCmd.Flags().StringVar(&serviceEnvFile, "env-file", "", "EnvironmentFile for secrets such as GITFS_PASSWORD")
+	serviceInstallCmd.Flags().DurationVar(&serviceWatchdog, "watchdog", 60*time.Second, "systemd watchdog timeout (0 disables it)")
+	serviceInstallCmd.Flags().BoolVar(&serviceEnable, "enable", false, "enable and start the service after installing it")
+	serviceInstallCmd.Flags().BoolVar(&servicePrint, "print", false, "print the unit instead of installing it")
+
+	serviceCmd.AddCommand(serviceInstallCmd)
+	rootCmd.AddCommand(serviceCmd)
+}
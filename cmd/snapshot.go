@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"git-fs/internal/config"
+	"git-fs/internal/control"
+	"git-fs/internal/daemon"
+	"git-fs/internal/logging"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Encrypt and commit the current state of the watch path",
+	Long: `Scans the whole watch path once, encrypts new and modified files, records deleted ones and commits
+the result, then pushes if a remote is configured. If a daemon is running for the repository, it takes the
+snapshot instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := logging.Logger
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			logger.Error("Failed to load config", zap.Error(err))
+			cmd.PrintErrln("Error: Could not load configuration. Please ensure config.yaml or ENV variables are set.")
+			return
+		}
+
+		// A running daemon owns the repository; let it do the work.
+		client := controlClient(cfg)
+		probe, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err = client.Status(probe)
+		cancel()
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			defer cancel()
+			if err := client.Do(ctx, control.ActionSnapshot); err != nil {
+				logger.Error("Snapshot through the daemon failed", zap.Error(err))
+				cmd.PrintErrf("Error: Snapshot failed: %v\n", err)
+				return
+			}
+			cmd.Println("Snapshot taken by the running daemon.")
+			return
+		}
+
+		repoLock, ok := lockRepository(cmd, cfg)
+		if !ok {
+			return
+		}
+		defer repoLock.Release()
+
+		d, err := daemon.New(cfg)
+		if err != nil {
+			logger.Error("Failed to open repository", zap.Error(err))
+			cmd.PrintErrln("Error: " + err.Error())
+			return
+		}
+
+		if err := d.Snapshot(); err != nil {
+			cmd.PrintErrln("Error: Snapshot failed. Check logs for details.")
+			return
+		}
+		if err := d.Sync(); err != nil {
+			cmd.PrintErrln("Warning: Snapshot committed but not pushed; the next snapshot or daemon run retries the push.")
+			return
+		}
+		cmd.Println("Snapshot taken.")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+}
@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"git-fs/internal/config"
 	"git-fs/internal/logging"
 	"git-fs/internal/status"
+	"io"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"time"
 
@@ -11,10 +18,17 @@ import (
 	"go.uber.org/zap"
 )
 
+var (
+	statusJSON     bool
+	statusWatch    bool
+	statusInterval time.Duration
+)
+
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show the current status of the daemon and repository",
-	Long:  `Displays whether the watcher is running, how many files are pending, and details of the last commit/push.`,
+	Long: `Displays whether the watcher is running, progress of the current batch, how many files are
+protected, and details of the last commit/push. Use --watch for live updates or --json for scripts.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		logger := logging.Logger
 
@@ -26,32 +40,157 @@ var statusCmd = &cobra.Command{
 			return
 		}
 
-		statusPath := filepath.Join(cfg.RepoPath, ".status.json")
-		st, err := status.LoadStatus(statusPath)
-		if err != nil {
-			logger.Error("Failed to load status", zap.String("path", statusPath), zap.Error(err))
-			cmd.PrintErrln("Error: Could not load status. Is the daemon running?")
+		if !statusWatch {
+			st, live, err := loadStatus(cfg)
+			if err != nil {
+				cmd.PrintErrln("Error: Could not load status. Is the daemon running?")
+				return
+			}
+			if statusJSON {
+				writeStatusJSON(cmd.OutOrStdout(), st, live)
+				return
+			}
+			printStatus(cmd.OutOrStderr(), st, live)
 			return
 		}
 
-		// Print out status in a user-friendly format
-		cmd.Println("git-fs Status:")
-		cmd.Printf("  Watcher running: %v\n", st.WatcherRunning)
-		cmd.Printf("  Files pending encryption: %d\n", st.FilesPending)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		for {
+			st, live, err := loadStatus(cfg)
+			switch {
+			case err != nil:
+				cmd.PrintErrln("Error: Could not load status. Is the daemon running?")
+			case statusJSON:
+				// One object per line, so the stream can be consumed incrementally.
+				writeStatusJSON(cmd.OutOrStdout(), st, live)
+			default:
+				var buf bytes.Buffer
+				buf.WriteString("\033[H\033[2J") // Clear the screen before redrawing
+				printStatus(&buf, st, live)
+				cmd.OutOrStderr().Write(buf.Bytes())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// loadStatus asks the running daemon first and falls back to the status
+// file, which may be stale or left behind by a daemon that died.
+func loadStatus(cfg *config.Config) (*status.Status, bool, error) {
+	logger := logging.Logger
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	st, err := controlClient(cfg).Status(ctx)
+	if err == nil {
+		return st, true, nil
+	}
+	logger.Debug("Daemon not reachable, reading status file", zap.Error(err))
 
-		if st.LastCommitHash == "" {
-			cmd.Println("  No commits recorded yet.")
+	statusPath := filepath.Join(cfg.RepoPath, ".status.json")
+	st, err = status.LoadStatus(statusPath)
+	if err != nil {
+		logger.Error("Failed to load status", zap.String("path", statusPath), zap.Error(err))
+		return nil, false, err
+	}
+	return st, false, nil
+}
+
+func writeStatusJSON(w io.Writer, st *status.Status, live bool) {
+	json.NewEncoder(w).Encode(struct {
+		Live          bool    `json:"live"`
+		UptimeSeconds float64 `json:"uptime_seconds"`
+		*status.Status
+	}{live, st.Uptime().Seconds(), st})
+}
+
+// printStatus prints st in a user-friendly format.
+func printStatus(w io.Writer, st *status.Status, live bool) {
+	fmt.Fprintln(w, "git-fs Status:")
+	if live {
+		fmt.Fprintf(w, "  Watcher running: %v\n", st.WatcherRunning)
+		if st.Watcher != "" {
+			fmt.Fprintf(w, "  Watching with: %s\n", st.Watcher)
+		}
+		fmt.Fprintf(w, "  Paused: %v\n", st.Paused)
+		fmt.Fprintf(w, "  Uptime: %s\n", st.Uptime().Round(time.Second))
+	} else {
+		fmt.Fprintln(w, "  Daemon not reachable; showing the last saved status.")
+		if st.WatcherRunning {
+			fmt.Fprintln(w, "  Watcher running: unknown (the status file says running, but the daemon did not answer)")
 		} else {
-			cmd.Printf("  Last commit: %s at %s\n", st.LastCommitHash, st.LastCommitTime.Format(time.RFC3339))
+			fmt.Fprintln(w, "  Watcher running: false")
 		}
+	}
+	fmt.Fprintf(w, "  Queued changes: %d\n", st.QueueDepth)
+	fmt.Fprintf(w, "  Files pending encryption: %d\n", st.FilesPending)
 
-		cmd.Printf("  Last push successful: %v\n", st.LastPushSuccessful)
-		if !st.LastPushTime.IsZero() {
-			cmd.Printf("  Last push time: %s\n", st.LastPushTime.Format(time.RFC3339))
+	if st.InBatch() {
+		fmt.Fprintf(w, "  Current batch: %d/%d files, %s/%s (%s/s)\n",
+			st.BatchFilesDone, st.BatchFiles,
+			formatBytes(st.BatchBytesDone), formatBytes(st.BatchBytes),
+			formatBytes(int64(st.BytesPerSecond)))
+		if st.CurrentFile != "" {
+			fmt.Fprintf(w, "  Current file: %s (%s)\n", st.CurrentFile, formatBytes(st.CurrentBytes))
 		}
-	},
+	}
+	fmt.Fprintf(w, "  Protected: %d files, %s\n", st.FilesProtected, formatBytes(st.BytesProtected))
+	if !st.LastSnapshot.IsZero() {
+		fmt.Fprintf(w, "  Last snapshot: %s\n", st.LastSnapshot.Format(time.RFC3339))
+	}
+	if !st.NextSnapshot.IsZero() {
+		fmt.Fprintf(w, "  Next snapshot: %s\n", st.NextSnapshot.Format(time.RFC3339))
+	}
+
+	if st.LastCommitHash == "" {
+		fmt.Fprintln(w, "  No commits recorded yet.")
+	} else {
+		fmt.Fprintf(w, "  Last commit: %s at %s\n", st.LastCommitHash, st.LastCommitTime.Format(time.RFC3339))
+	}
+
+	fmt.Fprintf(w, "  Last push successful: %v\n", st.LastPushSuccessful)
+	if !st.LastPushTime.IsZero() {
+		fmt.Fprintf(w, "  Last push time: %s\n", st.LastPushTime.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "  Unpushed commits: %d\n", st.UnpushedCommits)
+	if st.PushFailures > 0 {
+		fmt.Fprintf(w, "  Consecutive push failures: %d\n", st.PushFailures)
+	}
+	if !st.NextPushAttempt.IsZero() {
+		fmt.Fprintf(w, "  Next push attempt: %s\n", st.NextPushAttempt.Format(time.RFC3339))
+	}
+
+	if st.LastError != "" {
+		fmt.Fprintf(w, "  Last error: %s at %s\n", st.LastError, st.LastErrorTime.Format(time.RFC3339))
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 func init() {
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "print the status as JSON")
+	statusCmd.Flags().BoolVar(&statusWatch, "watch", false, "keep refreshing the status until interrupted")
+	statusCmd.Flags().DurationVar(&statusInterval, "interval", time.Second, "refresh interval for --watch")
 	rootCmd.AddCommand(statusCmd)
 }
@@ -4,7 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
 
+	"git-fs/internal/crypto"
+
+	"github.com/robfig/cron/v3"
 	"github.com/spf13/viper"
 )
 
@@ -12,13 +20,98 @@ var (
 	ErrNoPassword  = errors.New("no encryption password provided")
 	ErrNoRepoPath  = errors.New("no repository path provided")
 	ErrNoWatchPath = errors.New("no watch path provided")
+
+	ErrInvalidPushTiming    = errors.New("push_retry_interval and push_backoff_max must be positive")
+	ErrInvalidBatching      = errors.New("debounce, max_batch_wait, max_batch_files and min_commit_interval must not be negative")
+	ErrInvalidCommitMessage = errors.New("invalid commit_message template")
+	ErrInvalidSchedule      = errors.New("invalid schedule")
+	ErrInvalidWatcher       = errors.New("watcher must be auto, fsnotify or poll, with a positive poll_interval")
+	ErrInvalidCompression   = errors.New("invalid compression")
+	ErrInvalidConcurrency   = errors.New("concurrency and memory_budget must not be negative")
 )
 
+// DefaultCommitMessage is the commit_message template used when none is configured.
+const DefaultCommitMessage = "Automated encrypted backup: {{.Files}} files ({{.Written}} written, {{.Moved}} moved, {{.Updated}} updated, {{.Deleted}} deleted) on {{.Hostname}}"
+
 type Config struct {
 	Password  string
 	RepoPath  string
 	WatchPath string
 	RemoteURL string
+
+	// MetricsListen is the address of the Prometheus metrics endpoint; empty disables it.
+	MetricsListen string
+
+	// PushDelay groups commits made within this window into one push.
+	PushDelay time.Duration
+	// PushRetryInterval is how often unpushed commits are looked for without any file changes.
+	PushRetryInterval time.Duration
+	// PushBackoffMax caps the delay between retries of a failing push.
+	PushBackoffMax time.Duration
+
+	// Debounce is how long the watch path has to be quiet before a batch starts.
+	Debounce time.Duration
+	// MaxBatchWait bounds how long a change waits while events keep arriving; zero disables it.
+	MaxBatchWait time.Duration
+	// MaxBatchFiles and MaxBatchBytes split large change sets into several commits; zero means no limit.
+	MaxBatchFiles int
+	MaxBatchBytes int64
+	// MinCommitInterval is the least time between two automatic commits.
+	MinCommitInterval time.Duration
+
+	// CommitMessage is a text/template rendered for every commit.
+	CommitMessage string
+	// CommitMessagePaths makes plaintext paths available to CommitMessage.
+	// They end up unencrypted in the git history.
+	CommitMessagePaths bool
+
+	// Watch enables continuous watching of WatchPath for changes.
+	Watch bool
+	// Watcher selects how WatchPath is watched: auto, fsnotify or poll.
+	Watcher string
+	// PollInterval is how often the polling watcher walks WatchPath.
+	PollInterval time.Duration
+	// Schedule is a cron expression for full snapshots of WatchPath; empty disables them.
+	Schedule string
+
+	// Compression is what files are compressed with before encryption,
+	// unless one of CompressionOverrides matches them first.
+	Compression          crypto.Compression
+	CompressionOverrides []CompressionOverride
+
+	// Concurrency is how many files are read and encrypted at once; zero
+	// means one per CPU.
+	Concurrency int
+	// MemoryBudget bounds the size of the files being encrypted at once, so
+	// that large files are done one at a time; zero means no limit.
+	MemoryBudget int64
+
+	// PreserveOwnership records the owner and group of files, so that
+	// decrypt can restore them when run as root.
+	PreserveOwnership bool
+}
+
+// CompressionOverride sets the compression of files matching Pattern, a
+// filepath.Match pattern. Patterns without a slash match the base name of
+// a file, others its whole path relative to the watch path.
+type CompressionOverride struct {
+	Pattern     string
+	Compression crypto.Compression
+}
+
+// CompressionFor returns the compression for the file at relPath.
+func (c *Config) CompressionFor(relPath string) crypto.Compression {
+	relPath = filepath.ToSlash(relPath)
+	for _, o := range c.CompressionOverrides {
+		name := relPath
+		if !strings.Contains(o.Pattern, "/") {
+			name = path.Base(relPath)
+		}
+		if ok, _ := path.Match(o.Pattern, name); ok {
+			return o.Compression
+		}
+	}
+	return c.Compression
 }
 
 // LoadConfig attempts to load configuration from various sources.
@@ -39,6 +132,18 @@ func LoadConfig() (*Config, error) {
 	viper.SetEnvPrefix("GITFS")
 	viper.AutomaticEnv()
 
+	viper.SetDefault("push_delay", 5*time.Second)
+	viper.SetDefault("push_retry_interval", 5*time.Minute)
+	viper.SetDefault("push_backoff_max", time.Hour)
+	viper.SetDefault("debounce", 2*time.Second)
+	viper.SetDefault("max_batch_wait", time.Minute)
+	viper.SetDefault("commit_message", DefaultCommitMessage)
+	viper.SetDefault("watch", true)
+	viper.SetDefault("watcher", "auto")
+	viper.SetDefault("poll_interval", 10*time.Second)
+	viper.SetDefault("compression", crypto.DefaultCompression.String())
+	viper.SetDefault("memory_budget", "256MB")
+
 	// Try reading config file
 	err := viper.ReadInConfig()
 	if err != nil {
@@ -53,6 +158,31 @@ func LoadConfig() (*Config, error) {
 		RepoPath:  viper.GetString("repo_path"),
 		WatchPath: viper.GetString("watch_path"),
 		RemoteURL: viper.GetString("remote_url"),
+
+		MetricsListen: viper.GetString("metrics_listen"),
+
+		PushDelay:         viper.GetDuration("push_delay"),
+		PushRetryInterval: viper.GetDuration("push_retry_interval"),
+		PushBackoffMax:    viper.GetDuration("push_backoff_max"),
+
+		Debounce:          viper.GetDuration("debounce"),
+		MaxBatchWait:      viper.GetDuration("max_batch_wait"),
+		MaxBatchFiles:     viper.GetInt("max_batch_files"),
+		MaxBatchBytes:     int64(viper.GetSizeInBytes("max_batch_bytes")),
+		MinCommitInterval: viper.GetDuration("min_commit_interval"),
+
+		CommitMessage:      viper.GetString("commit_message"),
+		CommitMessagePaths: viper.GetBool("commit_message_paths"),
+
+		Watch:        viper.GetBool("watch"),
+		Watcher:      viper.GetString("watcher"),
+		PollInterval: viper.GetDuration("poll_interval"),
+		Schedule:     viper.GetString("schedule"),
+
+		Concurrency:  viper.GetInt("concurrency"),
+		MemoryBudget: int64(viper.GetSizeInBytes("memory_budget")),
+
+		PreserveOwnership: viper.GetBool("preserve_ownership"),
 	}
 
 	// Validate required fields
@@ -76,6 +206,58 @@ func LoadConfig() (*Config, error) {
 		return nil, ErrNoWatchPath
 	}
 
+	if cfg.PushRetryInterval <= 0 || cfg.PushBackoffMax <= 0 {
+		return nil, ErrInvalidPushTiming
+	}
+
+	if cfg.Debounce < 0 || cfg.MaxBatchWait < 0 || cfg.MaxBatchFiles < 0 || cfg.MinCommitInterval < 0 {
+		return nil, ErrInvalidBatching
+	}
+
+	if cfg.Concurrency < 0 || cfg.MemoryBudget < 0 {
+		return nil, ErrInvalidConcurrency
+	}
+
+	if _, err := template.New("commit_message").Parse(cfg.CommitMessage); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCommitMessage, err)
+	}
+
+	switch cfg.Watcher {
+	case "auto", "fsnotify", "poll":
+	default:
+		return nil, ErrInvalidWatcher
+	}
+	if cfg.PollInterval <= 0 {
+		return nil, ErrInvalidWatcher
+	}
+
+	if cfg.Schedule != "" {
+		if _, err := cron.ParseStandard(cfg.Schedule); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+	}
+
+	if cfg.Compression, err = crypto.ParseCompression(viper.GetString("compression")); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCompression, err)
+	}
+	var overrides []struct {
+		Pattern     string
+		Compression string
+	}
+	if err := viper.UnmarshalKey("compression_overrides", &overrides); err != nil {
+		return nil, fmt.Errorf("%w: compression_overrides: %v", ErrInvalidCompression, err)
+	}
+	for _, o := range overrides {
+		if _, err := path.Match(o.Pattern, ""); err != nil || o.Pattern == "" {
+			return nil, fmt.Errorf("%w: invalid pattern %q", ErrInvalidCompression, o.Pattern)
+		}
+		compression, err := crypto.ParseCompression(o.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidCompression, o.Pattern, err)
+		}
+		cfg.CompressionOverrides = append(cfg.CompressionOverrides, CompressionOverride{Pattern: o.Pattern, Compression: compression})
+	}
+
 	return cfg, nil
 }
 
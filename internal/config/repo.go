@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"git-fs/internal/crypto"
+	fileutils "git-fs/internal/fileutil"
+)
+
+// RepoSettingsFileName holds the RepoSettings, relative to the repo. It is
+// committed, so every clone writes the repository the same way.
+const RepoSettingsFileName = ".git-fs.json"
+
+// RepoSettings are chosen at init and stored in the repository itself,
+// unlike Config, which belongs to one machine.
+type RepoSettings struct {
+	// Cipher names the crypto.Suite new files are encrypted with.
+	Cipher string `json:"cipher"`
+	// Padding names the crypto.Padding new files are padded with.
+	Padding string `json:"padding"`
+	// Pack bundles small files into pack files, see package pack.
+	Pack bool `json:"pack"`
+}
+
+// DefaultRepoSettings returns the settings of a repository initialized
+// before they were stored.
+func DefaultRepoSettings() *RepoSettings {
+	return &RepoSettings{Cipher: crypto.AES256GCM.Name, Padding: crypto.PaddingNone.String()}
+}
+
+// LoadRepoSettings reads the settings of the repository at repoPath.
+func LoadRepoSettings(repoPath string) (*RepoSettings, error) {
+	settings := DefaultRepoSettings()
+	data, err := os.ReadFile(filepath.Join(repoPath, RepoSettingsFileName))
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		return nil, err
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// Validate checks that the settings name things this version supports.
+func (s *RepoSettings) Validate() error {
+	if _, err := crypto.SuiteByName(s.Cipher); err != nil {
+		return err
+	}
+	_, err := crypto.PaddingByName(s.Padding)
+	return err
+}
+
+// Suite returns the cipher suite named by Cipher.
+func (s *RepoSettings) Suite() *crypto.Suite {
+	suite, err := crypto.SuiteByName(s.Cipher)
+	if err != nil {
+		return crypto.AES256GCM
+	}
+	return suite
+}
+
+// PaddingScheme returns the padding scheme named by Padding.
+func (s *RepoSettings) PaddingScheme() crypto.Padding {
+	padding, err := crypto.PaddingByName(s.Padding)
+	if err != nil {
+		return crypto.PaddingNone
+	}
+	return padding
+}
+
+// Save writes the settings into the repository at repoPath.
+func (s *RepoSettings) Save(repoPath string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutils.WriteFileAtomic(filepath.Join(repoPath, RepoSettingsFileName), append(data, '\n'), 0644)
+}
@@ -0,0 +1,195 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"git-fs/internal/logging"
+	"git-fs/internal/status"
+
+	"go.uber.org/zap"
+)
+
+// SocketName is the control socket created in the repository root.
+const SocketName = ".git-fs.sock"
+
+// Actions accepted by the daemon in addition to status queries.
+const (
+	ActionPause    = "pause"
+	ActionResume   = "resume"
+	ActionSync     = "sync"
+	ActionFlush    = "flush"
+	ActionReload   = "reload"
+	ActionSnapshot = "snapshot"
+	ActionGC       = "gc"
+)
+
+var Actions = []string{ActionPause, ActionResume, ActionSync, ActionFlush, ActionReload, ActionSnapshot, ActionGC}
+
+// Handler is implemented by the daemon to serve control requests.
+type Handler interface {
+	Status() status.Status
+	Pause()
+	Resume()
+	// Sync processes pending changes and pushes right away.
+	Sync() error
+	// Flush processes pending changes without waiting for the debounce.
+	Flush() error
+	Reload() error
+	// Snapshot scans the whole watch path and commits what changed.
+	Snapshot() error
+	// GC repacks and removes unused objects and commits the result.
+	GC() error
+}
+
+type response struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Server exposes a Handler over HTTP on a Unix socket.
+type Server struct {
+	path string
+	ln   net.Listener
+	srv  *http.Server
+}
+
+// Listen creates the control socket at path. A socket left behind by a
+// daemon that is no longer running is replaced.
+func Listen(path string, h Handler) (*Server, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %s is in use by another daemon", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the owner may control the daemon.
+	ln, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.Status())
+	})
+	actions := map[string]func() error{
+		ActionPause:    func() error { h.Pause(); return nil },
+		ActionResume:   func() error { h.Resume(); return nil },
+		ActionSync:     h.Sync,
+		ActionFlush:    h.Flush,
+		ActionReload:   h.Reload,
+		ActionSnapshot: h.Snapshot,
+		ActionGC:       h.GC,
+	}
+	mux.HandleFunc("POST /v1/{action}", func(w http.ResponseWriter, r *http.Request) {
+		action, ok := actions[r.PathValue("action")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, response{Error: "unknown action " + r.PathValue("action")})
+			return
+		}
+		logging.Logger.Info("Control request", zap.String("action", r.PathValue("action")))
+		if err := action(); err != nil {
+			writeJSON(w, http.StatusInternalServerError, response{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, response{OK: true})
+	})
+
+	return &Server{path: path, ln: ln, srv: &http.Server{Handler: mux}}, nil
+}
+
+// Serve handles requests until Close is called.
+func (s *Server) Serve() error {
+	if err := s.srv.Serve(s.ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the server and removes the socket.
+func (s *Server) Close() error {
+	err := s.srv.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Client talks to a daemon's control socket.
+type Client struct {
+	http *http.Client
+}
+
+func NewClient(path string) *Client {
+	return &Client{http: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}}
+}
+
+// Status returns the live status of the daemon.
+func (c *Client) Status(ctx context.Context) (*status.Status, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://git-fs/v1/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("daemon returned %s", resp.Status)
+	}
+	var st status.Status
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// Do asks the daemon to perform action and waits for it to finish.
+func (c *Client) Do(ctx context.Context, action string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://git-fs/v1/"+action, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("daemon returned %s", resp.Status)
+	}
+	if !r.OK {
+		return errors.New(r.Error)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"git-fs/internal/logging"
+	"git-fs/internal/status"
+
+	"go.uber.org/zap"
+)
+
+// fakeHandler records the actions it is asked to perform and fails those in fail.
+type fakeHandler struct {
+	mu    sync.Mutex
+	calls []string
+	fail  map[string]error
+}
+
+func (h *fakeHandler) record(action string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, action)
+	return h.fail[action]
+}
+
+func (h *fakeHandler) Status() status.Status {
+	return status.Status{WatcherRunning: true, FilesPending: 3}
+}
+
+func (h *fakeHandler) Pause()          { h.record(ActionPause) }
+func (h *fakeHandler) Resume()         { h.record(ActionResume) }
+func (h *fakeHandler) Sync() error     { return h.record(ActionSync) }
+func (h *fakeHandler) Flush() error    { return h.record(ActionFlush) }
+func (h *fakeHandler) Reload() error   { return h.record(ActionReload) }
+func (h *fakeHandler) Snapshot() error { return h.record(ActionSnapshot) }
+func (h *fakeHandler) GC() error       { return h.record(ActionGC) }
+
+func TestControlRoundTrip(t *testing.T) {
+	logging.Logger = zap.NewNop()
+
+	// Socket paths are limited to about 100 bytes; keep it short.
+	dir, err := os.MkdirTemp("", "git-fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, SocketName)
+
+	h := &fakeHandler{fail: map[string]error{ActionGC: errors.New("repack failed")}}
+	server, err := Listen(path, h)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go server.Serve()
+	defer server.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode(); mode&os.ModeSocket == 0 || mode.Perm() != 0600 {
+		t.Errorf("expected a socket with mode 0600, got %v", mode)
+	}
+
+	if _, err := Listen(path, h); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("expected a second Listen to find the socket in use, got %v", err)
+	}
+
+	ctx := context.Background()
+	client := NewClient(path)
+
+	t.Run("Status", func(t *testing.T) {
+		st, err := client.Status(ctx)
+		if err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		if !st.WatcherRunning || st.FilesPending != 3 {
+			t.Errorf("unexpected status %+v", st)
+		}
+	})
+
+	t.Run("Actions", func(t *testing.T) {
+		for _, action := range Actions {
+			err := client.Do(ctx, action)
+			if action == ActionGC {
+				if err == nil || err.Error() != "repack failed" {
+					t.Errorf("expected the handler's error for %s, got %v", action, err)
+				}
+			} else if err != nil {
+				t.Errorf("%s failed: %v", action, err)
+			}
+		}
+		if got, want := strings.Join(h.calls, ","), strings.Join(Actions, ","); got != want {
+			t.Errorf("handler called for %s, want %s", got, want)
+		}
+	})
+
+	t.Run("Unknown action", func(t *testing.T) {
+		if err := client.Do(ctx, "explode"); err == nil || !strings.Contains(err.Error(), "unknown action") {
+			t.Errorf("expected an unknown action error, got %v", err)
+		}
+	})
+
+	if err := server.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket left behind after Close: %v", err)
+	}
+}
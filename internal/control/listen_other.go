@@ -0,0 +1,11 @@
+//go:build !unix
+
+package control
+
+import "net"
+
+// listenPrivate listens on a Unix socket at path; its permissions are set
+// once it exists.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package control
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate listens on a Unix socket at path that only the owner can
+// connect to from the start. The umask is process wide, but tightening it
+// for a moment only ever makes files created meanwhile more private.
+func listenPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
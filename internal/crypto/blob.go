@@ -0,0 +1,246 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Formats of encrypted file content, recorded in the file metadata.
+const (
+	// BlobFormatLegacy is bare ciphertext, with the nonce kept in the
+	// metadata. Blobs could be swapped between paths undetected.
+	BlobFormatLegacy = 0
+	// BlobFormatV1 starts with a header that is authenticated along with
+	// the object id, see SealBlob. Always AES-256-GCM.
+	BlobFormatV1 = 1
+	// BlobFormatV2 adds the cipher suite to the header.
+	BlobFormatV2 = 2
+	// BlobFormatV3 adds the compression codec; gzip before.
+	BlobFormatV3 = 3
+	// BlobFormatV4 prefixes the compressed content with its length inside
+	// the ciphertext, which allows it to be padded, see Padding.
+	BlobFormatV4 = 4
+)
+
+// Formats of the metadata store: bare ciphertext before the header was
+// introduced, then a header carrying the rollback counter, see SealMetadata,
+// then the cipher suite as well. They match the blob formats.
+const (
+	MetadataFormatLegacy = 0
+	MetadataFormatV1     = 1
+	MetadataFormatV2     = 2
+)
+
+const (
+	blobMagic     = "GFSB"
+	metadataMagic = "GFSM"
+
+	blobFieldSize     = 4 // Key epoch
+	metadataFieldSize = 8 // Rollback counter
+)
+
+// ErrUnknownFormat is returned for sealed data in a format this version
+// can't read.
+var ErrUnknownFormat = errors.New("unknown encryption format")
+
+// header is the start of sealed data, authenticated along with it:
+//
+//	magic   4 bytes
+//	format  1 byte
+//	suite   1 byte, from format 2 on; AES-256-GCM before
+//	codec   1 byte, from format 3 on; gzip before. Blobs only.
+//	field   the key epoch of a blob or the counter of the metadata store
+//	nonce   as long as the suite needs
+type header struct {
+	raw    []byte
+	format byte
+	suite  *Suite
+	codec  byte
+	field  uint64
+}
+
+func (h header) nonce() []byte {
+	return h.raw[len(h.raw)-h.suite.NonceSize:]
+}
+
+// newHeader returns a header in format with a random nonce.
+func newHeader(magic string, format byte, suite *Suite, codec byte, fieldSize int, field uint64) (header, error) {
+	raw := make([]byte, 0, len(magic)+3+fieldSize+suite.NonceSize)
+	raw = append(raw, magic...)
+	raw = append(raw, format, suite.ID)
+	if format >= BlobFormatV3 {
+		raw = append(raw, codec)
+	}
+	if fieldSize == 4 {
+		raw = binary.BigEndian.AppendUint32(raw, uint32(field))
+	} else {
+		raw = binary.BigEndian.AppendUint64(raw, field)
+	}
+	nonce := make([]byte, suite.NonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return header{}, err
+	}
+	raw = append(raw, nonce...)
+	return header{raw: raw, format: format, suite: suite, codec: codec, field: field}, nil
+}
+
+// parseHeader reads the header at the start of data.
+func parseHeader(data []byte, magic string, fieldSize int) (header, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) || len(data) <= len(magic) {
+		return header{}, errors.New("encrypted data has no header")
+	}
+	h := header{format: data[len(magic)], suite: AES256GCM, codec: CodecGzip}
+	n := len(magic) + 1
+	if h.format < BlobFormatV1 || h.format > BlobFormatV4 {
+		return header{}, fmt.Errorf("%w: format %d", ErrUnknownFormat, h.format)
+	}
+	if h.format >= BlobFormatV2 {
+		if len(data) <= n {
+			return header{}, errors.New("encrypted data too short")
+		}
+		suite, err := suiteByID(data[n])
+		if err != nil {
+			return header{}, err
+		}
+		h.suite = suite
+		n++
+	}
+	if h.format >= BlobFormatV3 {
+		if len(data) <= n {
+			return header{}, errors.New("encrypted data too short")
+		}
+		h.codec = data[n]
+		n++
+	}
+
+	if len(data) < n+fieldSize+h.suite.NonceSize {
+		return header{}, errors.New("encrypted data too short")
+	}
+	if fieldSize == 4 {
+		h.field = uint64(binary.BigEndian.Uint32(data[n:]))
+	} else {
+		h.field = binary.BigEndian.Uint64(data[n:])
+	}
+	n += fieldSize + h.suite.NonceSize
+	h.raw = data[:n:n]
+	return h, nil
+}
+
+// SealOptions selects how SealBlob encrypts.
+type SealOptions struct {
+	Suite       *Suite
+	Compression Compression
+	Padding     Padding
+}
+
+// SealBlob compresses, pads and encrypts content as the blob stored under
+// objectID. The header and objectID are authenticated, so the blob can't
+// be passed off as another object or as written with another key.
+func SealBlob(keys *Keys, opts SealOptions, objectID string, content []byte) ([]byte, error) {
+	aead, err := opts.Suite.AEAD(keys.Content)
+	if err != nil {
+		return nil, err
+	}
+	codec, compressed, err := compress(opts.Compression, content)
+	if err != nil {
+		return nil, err
+	}
+	h, err := newHeader(blobMagic, BlobFormatV4, opts.Suite, codec, blobFieldSize, KeyVersionSubkeys)
+	if err != nil {
+		return nil, err
+	}
+
+	aad := append(bytes.Clone(h.raw), objectID...)
+	return aead.Seal(bytes.Clone(h.raw), h.nonce(), pad(opts.Padding, compressed), aad), nil
+}
+
+// OpenBlob decrypts and decompresses a blob written by SealBlob for
+// objectID, with whichever suite it was sealed with.
+func OpenBlob(keys *Keys, objectID string, blob []byte) ([]byte, error) {
+	h, err := parseHeader(blob, blobMagic, blobFieldSize)
+	if err != nil {
+		return nil, err
+	}
+	if h.field != KeyVersionLegacy && h.field != KeyVersionSubkeys {
+		return nil, fmt.Errorf("%w: key epoch %d", ErrUnknownFormat, h.field)
+	}
+
+	aead, err := h.suite.AEAD(keys.ContentKey(int(h.field)))
+	if err != nil {
+		return nil, err
+	}
+	aad := append(bytes.Clone(h.raw), objectID...)
+	compressed, err := aead.Open(nil, h.nonce(), blob[len(h.raw):], aad)
+	if err != nil {
+		return nil, err
+	}
+	if h.format >= BlobFormatV4 {
+		if compressed, err = unpad(compressed); err != nil {
+			return nil, err
+		}
+	}
+	return decompress(h.codec, compressed)
+}
+
+// OpenLegacyBlob decrypts and decompresses a blob written by EncryptFile
+// with nonce.
+func OpenLegacyBlob(key, nonce, blob []byte) ([]byte, error) {
+	aead, err := AES256GCM.AEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != NonceSize {
+		return nil, errors.New("invalid nonce size")
+	}
+	compressed, err := aead.Open(nil, nonce, blob, nil)
+	if err != nil {
+		return nil, err
+	}
+	return Decompress(compressed)
+}
+
+// SealMetadata encrypts the metadata store with suite and counter, which is
+// authenticated so that an older store can't be passed off as a newer one.
+func SealMetadata(key []byte, suite *Suite, counter uint64, data []byte) ([]byte, error) {
+	aead, err := suite.AEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	h, err := newHeader(metadataMagic, MetadataFormatV2, suite, CodecNone, metadataFieldSize, counter)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(bytes.Clone(h.raw), h.nonce(), data, h.raw), nil
+}
+
+// OpenMetadata decrypts a metadata store written by SealMetadata and
+// returns its counter. Stores written by Encrypt, before the header was
+// introduced, are reported as MetadataFormatLegacy with a counter of 0.
+func OpenMetadata(key, sealed []byte) (data []byte, counter uint64, format int, err error) {
+	h, herr := parseHeader(sealed, metadataMagic, metadataFieldSize)
+	if herr == nil {
+		aead, err := h.suite.AEAD(key)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		data, err := aead.Open(nil, h.nonce(), sealed[len(h.raw):], h.raw)
+		if err == nil {
+			return data, h.field, int(h.format), nil
+		}
+	}
+
+	// A legacy store may happen to start with the magic.
+	data, err = Decrypt(key, sealed)
+	if err != nil {
+		if errors.Is(herr, ErrUnknownFormat) || errors.Is(herr, ErrUnknownSuite) {
+			// Most likely written by a newer version.
+			return nil, 0, 0, herr
+		}
+		return nil, 0, 0, err
+	}
+	return data, 0, MetadataFormatLegacy, nil
+}
@@ -0,0 +1,176 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testKeys(t *testing.T) *Keys {
+	t.Helper()
+	key := make([]byte, KeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	keys, err := DeriveKeys(key)
+	if err != nil {
+		t.Fatalf("DeriveKeys failed: %v", err)
+	}
+	return keys
+}
+
+func TestBlob(t *testing.T) {
+	keys := testKeys(t)
+	content := []byte("This is file content")
+
+	for _, suite := range Suites {
+		t.Run(suite.Name, func(t *testing.T) {
+			blob, err := SealBlob(keys, SealOptions{Suite: suite, Compression: DefaultCompression}, "object", content)
+			if err != nil {
+				t.Fatalf("SealBlob failed: %v", err)
+			}
+
+			opened, err := OpenBlob(keys, "object", blob)
+			if err != nil {
+				t.Fatalf("OpenBlob failed: %v", err)
+			}
+			if !bytes.Equal(opened, content) {
+				t.Errorf("got %q, want %q", opened, content)
+			}
+
+			if _, err := OpenBlob(keys, "other", blob); err == nil {
+				t.Error("expected an error for a blob opened as another object")
+			}
+
+			// Every header byte is authenticated: the suite, codec and key epoch...
+			for i := len(blobMagic) + 1; i < len(blobMagic)+3+blobFieldSize; i++ {
+				tampered := bytes.Clone(blob)
+				tampered[i] ^= 1
+				if _, err := OpenBlob(keys, "object", tampered); err == nil {
+					t.Errorf("expected an error for a changed header byte %d", i)
+				}
+			}
+
+			if _, err := OpenBlob(keys, "object", blob[len(blobMagic):]); err == nil {
+				t.Error("expected an error for a blob without header")
+			}
+		})
+	}
+
+	t.Run("Format 1", func(t *testing.T) {
+		// Format 1 had no suite byte and was always AES-256-GCM.
+		h := []byte(blobMagic + "\x01\x00\x00\x00\x01")
+		h = append(h, make([]byte, NonceSize)...)
+		aead, _ := AES256GCM.AEAD(keys.Content)
+		compressed, _ := Compress(content)
+		blob := aead.Seal(bytes.Clone(h), h[len(h)-NonceSize:], compressed, append(bytes.Clone(h), "object"...))
+
+		opened, err := OpenBlob(keys, "object", blob)
+		if err != nil {
+			t.Fatalf("OpenBlob failed: %v", err)
+		}
+		if !bytes.Equal(opened, content) {
+			t.Errorf("got %q, want %q", opened, content)
+		}
+	})
+
+	t.Run("Format 3", func(t *testing.T) {
+		// Format 3 had no length prefix and so couldn't be padded.
+		h := []byte(blobMagic + "\x03\x01\x00\x00\x00\x00\x01")
+		h = append(h, make([]byte, NonceSize)...)
+		aead, _ := AES256GCM.AEAD(keys.Content)
+		blob := aead.Seal(bytes.Clone(h), h[len(h)-NonceSize:], content, append(bytes.Clone(h), "object"...))
+
+		opened, err := OpenBlob(keys, "object", blob)
+		if err != nil {
+			t.Fatalf("OpenBlob failed: %v", err)
+		}
+		if !bytes.Equal(opened, content) {
+			t.Errorf("got %q, want %q", opened, content)
+		}
+	})
+
+	t.Run("Padding", func(t *testing.T) {
+		// Content of different sizes ends up in blobs of the same size.
+		var sizes []int
+		for _, n := range []int{100, 110, 120} {
+			plain := bytes.Repeat([]byte{'x'}, n)
+			blob, err := SealBlob(keys, SealOptions{Suite: AES256GCM, Padding: PaddingPow2}, "object", plain)
+			if err != nil {
+				t.Fatalf("SealBlob failed: %v", err)
+			}
+			opened, err := OpenBlob(keys, "object", blob)
+			if err != nil || !bytes.Equal(opened, plain) {
+				t.Fatalf("OpenBlob failed: %v", err)
+			}
+			sizes = append(sizes, len(blob))
+		}
+		if sizes[0] != sizes[1] || sizes[1] != sizes[2] {
+			t.Errorf("padded blob sizes differ: %v", sizes)
+		}
+	})
+
+	t.Run("Unknown suite", func(t *testing.T) {
+		blob, _ := SealBlob(keys, SealOptions{Suite: AES256GCM}, "object", content)
+		blob[len(blobMagic)+1] = 99
+		if _, err := OpenBlob(keys, "object", blob); !errors.Is(err, ErrUnknownSuite) {
+			t.Errorf("expected ErrUnknownSuite, got %v", err)
+		}
+	})
+
+	t.Run("Legacy blob", func(t *testing.T) {
+		nonce := make([]byte, NonceSize)
+		legacy, err := EncryptFile(keys.Legacy, content, nonce)
+		if err != nil {
+			t.Fatalf("EncryptFile failed: %v", err)
+		}
+		opened, err := OpenLegacyBlob(keys.Legacy, nonce, legacy)
+		if err != nil {
+			t.Fatalf("OpenLegacyBlob failed: %v", err)
+		}
+		if !bytes.Equal(opened, content) {
+			t.Errorf("got %q, want %q", opened, content)
+		}
+	})
+}
+
+func TestMetadataSealing(t *testing.T) {
+	keys := testKeys(t)
+	data := []byte(`{"metadata":{}}`)
+
+	for _, suite := range Suites {
+		t.Run(suite.Name, func(t *testing.T) {
+			sealed, err := SealMetadata(keys.Metadata, suite, 42, data)
+			if err != nil {
+				t.Fatalf("SealMetadata failed: %v", err)
+			}
+			opened, counter, format, err := OpenMetadata(keys.Metadata, sealed)
+			if err != nil {
+				t.Fatalf("OpenMetadata failed: %v", err)
+			}
+			if !bytes.Equal(opened, data) || counter != 42 || format != MetadataFormatV2 {
+				t.Errorf("got %q, counter %d, format %d", opened, counter, format)
+			}
+
+			// The counter is authenticated.
+			sealed[len(metadataMagic)+2+metadataFieldSize-1] = 43
+			if _, _, _, err := OpenMetadata(keys.Metadata, sealed); err == nil {
+				t.Error("expected an error for a changed counter")
+			}
+		})
+	}
+
+	t.Run("Legacy store", func(t *testing.T) {
+		sealed, err := Encrypt(keys.Metadata, data)
+		if err != nil {
+			t.Fatalf("Encrypt failed: %v", err)
+		}
+		opened, counter, format, err := OpenMetadata(keys.Metadata, sealed)
+		if err != nil {
+			t.Fatalf("OpenMetadata failed: %v", err)
+		}
+		if !bytes.Equal(opened, data) || counter != 0 || format != MetadataFormatLegacy {
+			t.Errorf("got %q, counter %d, format %d", opened, counter, format)
+		}
+	})
+}
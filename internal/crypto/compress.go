@@ -0,0 +1,202 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs file content can be compressed with before it is encrypted. The
+// one used is recorded in the blob header.
+const (
+	CodecNone byte = 0
+	CodecGzip byte = 1
+	CodecZstd byte = 2
+)
+
+var codecNames = map[byte]string{CodecNone: "none", CodecGzip: "gzip", CodecZstd: "zstd"}
+
+// ErrInvalidCompression is returned by ParseCompression.
+var ErrInvalidCompression = errors.New("compression must be none, gzip or zstd, optionally followed by :level")
+
+// Compression selects a codec and its level. Level 0 is the codec's default.
+type Compression struct {
+	Codec byte
+	Level int
+}
+
+// DefaultCompression is what files are compressed with unless configured
+// otherwise: gzip, as before codecs could be chosen.
+var DefaultCompression = Compression{Codec: CodecGzip}
+
+// ParseCompression parses "none", "gzip" or "zstd", optionally followed by
+// a level, as in "zstd:19".
+func ParseCompression(s string) (Compression, error) {
+	name, level, hasLevel := strings.Cut(strings.TrimSpace(s), ":")
+	var c Compression
+	switch name {
+	case "none":
+		c.Codec = CodecNone
+	case "gzip":
+		c.Codec = CodecGzip
+	case "zstd":
+		c.Codec = CodecZstd
+	default:
+		return c, ErrInvalidCompression
+	}
+	if hasLevel {
+		n, err := strconv.Atoi(level)
+		if err != nil || c.Codec == CodecNone ||
+			(c.Codec == CodecGzip && (n < gzip.BestSpeed || n > gzip.BestCompression)) ||
+			(c.Codec == CodecZstd && (n < 1 || n > 22)) {
+			return c, fmt.Errorf("%w: invalid level %q for %s", ErrInvalidCompression, level, name)
+		}
+		c.Level = n
+	}
+	return c, nil
+}
+
+func (c Compression) String() string {
+	if c.Level == 0 {
+		return codecNames[c.Codec]
+	}
+	return fmt.Sprintf("%s:%d", codecNames[c.Codec], c.Level)
+}
+
+const (
+	// incompressibleEntropy is the entropy in bits per byte above which
+	// data is taken to be compressed or encrypted already.
+	incompressibleEntropy = 7.5
+	// entropySampleSize is how much of the data is sampled, in a few pieces
+	// spread over it.
+	entropySampleSize = 3 * 4096
+	// minSaving is the fraction of the size compression has to save for
+	// the compressed data to be kept.
+	minSaving = 0.03
+)
+
+// compress compresses data with c, unless it doesn't look compressible or
+// compressing it doesn't save enough. It returns the codec actually used.
+func compress(c Compression, data []byte) (byte, []byte, error) {
+	if c.Codec == CodecNone || len(data) == 0 || looksCompressed(data) {
+		return CodecNone, data, nil
+	}
+
+	var out []byte
+	switch c.Codec {
+	case CodecGzip:
+		level := c.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		var buf bytes.Buffer
+		gz, err := gzip.NewWriterLevel(&buf, level)
+		if err != nil {
+			return 0, nil, err
+		}
+		if _, err := gz.Write(data); err != nil {
+			return 0, nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return 0, nil, err
+		}
+		out = buf.Bytes()
+	case CodecZstd:
+		enc, err := zstdEncoder(c.Level)
+		if err != nil {
+			return 0, nil, err
+		}
+		out = enc.EncodeAll(data, nil)
+	default:
+		return 0, nil, fmt.Errorf("%w: codec %d", ErrUnknownFormat, c.Codec)
+	}
+
+	if float64(len(out)) > float64(len(data))*(1-minSaving) {
+		return CodecNone, data, nil
+	}
+	return c.Codec, out, nil
+}
+
+// decompress reverses compress for codec.
+func decompress(codec byte, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+	case CodecGzip:
+		return Decompress(data)
+	case CodecZstd:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("%w: codec %d", ErrUnknownFormat, codec)
+	}
+}
+
+// looksCompressed samples data and reports whether its entropy is as high
+// as that of compressed or encrypted data, which isn't worth compressing.
+func looksCompressed(data []byte) bool {
+	var counts [256]int
+	var n int
+	if len(data) <= entropySampleSize {
+		// Small enough to just try.
+		return false
+	}
+	piece := entropySampleSize / 3
+	for _, start := range []int{0, len(data)/2 - piece/2, len(data) - piece} {
+		for _, b := range data[start : start+piece] {
+			counts[b]++
+		}
+		n += piece
+	}
+
+	var entropy float64
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(n)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy > incompressibleEntropy
+}
+
+var (
+	zstdEncodersMu sync.Mutex
+	zstdEncoders   = make(map[int]*zstd.Encoder)
+
+	// zstdDecoder returns a shared decoder, which is safe for concurrent
+	// DecodeAll calls.
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil)
+	})
+)
+
+// zstdEncoder returns a shared encoder for level, which is safe for
+// concurrent EncodeAll calls.
+func zstdEncoder(level int) (*zstd.Encoder, error) {
+	zstdEncodersMu.Lock()
+	defer zstdEncodersMu.Unlock()
+
+	if enc, ok := zstdEncoders[level]; ok {
+		return enc, nil
+	}
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[level] = enc
+	return enc, nil
+}
@@ -0,0 +1,82 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Compression
+		ok   bool
+	}{
+		{"none", Compression{Codec: CodecNone}, true},
+		{"gzip", Compression{Codec: CodecGzip}, true},
+		{"gzip:9", Compression{Codec: CodecGzip, Level: 9}, true},
+		{"zstd:19", Compression{Codec: CodecZstd, Level: 19}, true},
+		{"gzip:10", Compression{}, false},
+		{"zstd:0", Compression{}, false},
+		{"none:1", Compression{}, false},
+		{"lz4", Compression{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseCompression(tt.in)
+			if !tt.ok {
+				if !errors.Is(err, ErrInvalidCompression) {
+					t.Errorf("expected ErrInvalidCompression, got %v", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %v, %v, want %v", got, err, tt.want)
+			}
+			if got.String() != tt.in {
+				t.Errorf("String() = %q, want %q", got.String(), tt.in)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	text := bytes.Repeat([]byte("compressible text, "), 1000)
+	random := make([]byte, 64*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []Compression{{Codec: CodecGzip}, {Codec: CodecZstd}, {Codec: CodecZstd, Level: 19}} {
+		t.Run(c.String(), func(t *testing.T) {
+			codec, out, err := compress(c, text)
+			if err != nil {
+				t.Fatalf("compress failed: %v", err)
+			}
+			if codec != c.Codec || len(out) >= len(text) {
+				t.Errorf("text compressed with codec %d to %d bytes", codec, len(out))
+			}
+			back, err := decompress(codec, out)
+			if err != nil || !bytes.Equal(back, text) {
+				t.Errorf("roundtrip failed: %v", err)
+			}
+
+			// Random data is stored as is, whether caught by sampling or
+			// by the size check.
+			for _, data := range [][]byte{random, random[:100]} {
+				codec, out, err := compress(c, data)
+				if err != nil || codec != CodecNone || !bytes.Equal(out, data) {
+					t.Errorf("random data of %d bytes compressed with codec %d: %v", len(data), codec, err)
+				}
+			}
+		})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		codec, out, err := compress(Compression{Codec: CodecNone}, text)
+		if err != nil || codec != CodecNone || !bytes.Equal(out, text) {
+			t.Errorf("got codec %d, %v", codec, err)
+		}
+	})
+}
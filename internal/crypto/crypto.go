@@ -9,7 +9,6 @@ import (
 	"encoding/base64"
 	"errors"
 	"io"
-	"os"
 )
 
 const (
@@ -79,7 +78,8 @@ func EncryptFileName(key []byte, name string) (string, []byte, []byte, error) {
 	return encodedName, fileNonce, nameNonce, nil
 }
 
-// EncryptFile encrypts file content using the provided key and nonce
+// EncryptFile encrypts file content using the provided key and nonce, in
+// BlobFormatLegacy. New blobs are written with SealBlob.
 func EncryptFile(key []byte, content []byte, nonce []byte) ([]byte, error) {
 	if len(key) != KeySize {
 		return nil, errors.New("invalid key size")
@@ -141,49 +141,6 @@ func Encrypt(key []byte, data []byte) ([]byte, error) {
 	return append(nonce, encrypted...), nil
 }
 
-// DecryptFile decrypts a file and writes it to the destination path
-func DecryptFile(key []byte, sourcePath, destPath string) error {
-	// Read encrypted file
-	encryptedData, err := os.ReadFile(sourcePath)
-	if err != nil {
-		return err
-	}
-
-	if len(encryptedData) < NonceSize {
-		return errors.New("encrypted file too short")
-	}
-
-	// Extract nonce from the start of file
-	nonce := encryptedData[:NonceSize]
-	ciphertext := encryptedData[NonceSize:]
-
-	// Create cipher block
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return err
-	}
-
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return err
-	}
-
-	// Decrypt the file content
-	decrypted, err := gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return err
-	}
-
-	// Decompress data
-	decompressed, err := Decompress(decrypted)
-	if err != nil {
-		return err
-	}
-	// Write decrypted content to destination
-	return os.WriteFile(destPath, decompressed, 0600)
-}
-
 // Decrypt decrypts data that was encrypted with Encrypt()
 func Decrypt(key []byte, data []byte) ([]byte, error) {
 	if len(key) != KeySize {
@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Purposes a subkey can be derived for. Each one yields an independent key,
+// so the repository key itself is never used for more than one thing.
+const (
+	PurposeName     = "name"     // File name encryption
+	PurposeContent  = "content"  // File content encryption
+	PurposeMetadata = "metadata" // The metadata store
+	PurposeMAC      = "mac"      // Keyed fingerprints of plaintext content
+	PurposeChunkID  = "chunk-id" // Identifiers of content-addressed chunks
+)
+
+// Key versions, recorded with each encrypted file so that files written
+// before subkeys were introduced can still be read.
+const (
+	KeyVersionLegacy  = 0 // The repository key itself
+	KeyVersionSubkeys = 1 // The subkey for the purpose
+)
+
+// Keys holds the subkeys derived from a repository key.
+type Keys struct {
+	Name     []byte
+	Content  []byte
+	Metadata []byte
+	MAC      []byte
+	ChunkID  []byte
+
+	// Legacy is the repository key, which older versions used directly for
+	// names, content and metadata. It is only used to read what they wrote.
+	Legacy []byte
+}
+
+// DeriveKeys derives the subkey for every purpose from the repository key.
+func DeriveKeys(key []byte) (*Keys, error) {
+	keys := &Keys{Legacy: key}
+	for _, sub := range []struct {
+		purpose string
+		key     *[]byte
+	}{
+		{PurposeName, &keys.Name},
+		{PurposeContent, &keys.Content},
+		{PurposeMetadata, &keys.Metadata},
+		{PurposeMAC, &keys.MAC},
+		{PurposeChunkID, &keys.ChunkID},
+	} {
+		subKey, err := SubKey(key, sub.purpose)
+		if err != nil {
+			return nil, err
+		}
+		*sub.key = subKey
+	}
+	return keys, nil
+}
+
+// ContentKey returns the key file content of the given key version was
+// encrypted with.
+func (k *Keys) ContentKey(version int) []byte {
+	if version == KeyVersionLegacy {
+		return k.Legacy
+	}
+	return k.Content
+}
+
+// SubKey derives the key for purpose from the repository key with HKDF-SHA256.
+func SubKey(key []byte, purpose string) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("invalid key size")
+	}
+	subKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("git-fs "+purpose)), subKey); err != nil {
+		return nil, err
+	}
+	return subKey, nil
+}
+
+// ContentMAC returns the HMAC-SHA256 of data under macKey. Unlike a plain
+// hash it can't be used to confirm that a known file is in the repository
+// without the key.
+func ContentMAC(macKey, data []byte) string {
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(data)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,81 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSubKey(t *testing.T) {
+	key := make([]byte, KeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	macKey, err := SubKey(key, PurposeMAC)
+	if err != nil {
+		t.Fatalf("SubKey failed: %v", err)
+	}
+	if len(macKey) != KeySize {
+		t.Errorf("subkey has %d bytes, want %d", len(macKey), KeySize)
+	}
+	if bytes.Equal(macKey, key) {
+		t.Error("subkey equals the repository key")
+	}
+	again, _ := SubKey(key, PurposeMAC)
+	if !bytes.Equal(macKey, again) {
+		t.Error("SubKey is not deterministic")
+	}
+	other, _ := SubKey(key, "other")
+	if bytes.Equal(macKey, other) {
+		t.Error("different purposes yield the same subkey")
+	}
+
+	if _, err := SubKey(key[:16], PurposeMAC); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
+
+func TestContentMAC(t *testing.T) {
+	data := []byte("content")
+	a := ContentMAC([]byte("key a"), data)
+	if a != ContentMAC([]byte("key a"), data) {
+		t.Error("ContentMAC is not deterministic")
+	}
+	if a == ContentMAC([]byte("key b"), data) {
+		t.Error("ContentMAC ignores the key")
+	}
+	if a == ContentMAC([]byte("key a"), []byte("other")) {
+		t.Error("ContentMAC ignores the data")
+	}
+}
+
+func TestDeriveKeys(t *testing.T) {
+	key := make([]byte, KeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	keys, err := DeriveKeys(key)
+	if err != nil {
+		t.Fatalf("DeriveKeys failed: %v", err)
+	}
+	all := [][]byte{keys.Name, keys.Content, keys.Metadata, keys.MAC, keys.ChunkID, keys.Legacy}
+	for i := range all {
+		for j := i + 1; j < len(all); j++ {
+			if bytes.Equal(all[i], all[j]) {
+				t.Errorf("keys %d and %d are equal", i, j)
+			}
+		}
+	}
+
+	mac, _ := SubKey(key, PurposeMAC)
+	if !bytes.Equal(keys.MAC, mac) {
+		t.Error("MAC key differs from the MAC subkey")
+	}
+	if !bytes.Equal(keys.ContentKey(KeyVersionLegacy), key) {
+		t.Error("legacy content key is not the repository key")
+	}
+	if !bytes.Equal(keys.ContentKey(KeyVersionSubkeys), keys.Content) {
+		t.Error("content key is not the content subkey")
+	}
+}
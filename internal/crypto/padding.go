@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Padding is a scheme for rounding up the size of sealed content, so that
+// blob sizes reveal less about the size of the files they hold.
+type Padding byte
+
+const (
+	// PaddingNone keeps the exact size.
+	PaddingNone Padding = 0
+	// PaddingPadme pads to a PADMÉ size, which costs at most 12% and leaks
+	// O(log log n) bits of the size.
+	PaddingPadme Padding = 1
+	// PaddingPow2 pads to the next power of two, which costs up to 100% but
+	// leaves only O(log n) distinct sizes.
+	PaddingPow2 Padding = 2
+)
+
+// Paddings lists the supported padding schemes.
+var Paddings = []Padding{PaddingNone, PaddingPadme, PaddingPow2}
+
+var paddingNames = map[Padding]string{PaddingNone: "none", PaddingPadme: "padme", PaddingPow2: "pow2"}
+
+// ErrUnknownPadding is returned for a padding scheme that doesn't exist.
+var ErrUnknownPadding = errors.New("unknown padding")
+
+// PaddingByName returns the padding scheme called name.
+func PaddingByName(name string) (Padding, error) {
+	for p, n := range paddingNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return PaddingNone, fmt.Errorf("%w %q", ErrUnknownPadding, name)
+}
+
+func (p Padding) String() string {
+	return paddingNames[p]
+}
+
+// Size returns the size n bytes are padded to.
+func (p Padding) Size(n int) int {
+	if n <= 2 {
+		return n
+	}
+	switch p {
+	case PaddingPadme:
+		// Keep the top log2(log2(n))+1 bits of n and round up the rest.
+		e := bits.Len(uint(n)) - 1
+		s := bits.Len(uint(e))
+		mask := 1<<(e-s) - 1
+		return (n + mask) &^ mask
+	case PaddingPow2:
+		return 1 << bits.Len(uint(n-1))
+	default:
+		return n
+	}
+}
+
+// lengthSize is the size of the length prefix of padded content.
+const lengthSize = 8
+
+// pad prefixes data with its length and pads the result with zeros to the
+// size p picks. The length is sealed along with the data, so it is both
+// hidden and authenticated.
+func pad(p Padding, data []byte) []byte {
+	n := lengthSize + len(data)
+	out := make([]byte, lengthSize, p.Size(n))
+	binary.BigEndian.PutUint64(out, uint64(len(data)))
+	out = append(out, data...)
+	return out[:cap(out)]
+}
+
+// unpad reverses pad.
+func unpad(data []byte) ([]byte, error) {
+	if len(data) < lengthSize {
+		return nil, errors.New("padded data too short")
+	}
+	n := binary.BigEndian.Uint64(data)
+	if n > uint64(len(data)-lengthSize) {
+		return nil, errors.New("padded data has an invalid length")
+	}
+	return data[lengthSize : lengthSize+int(n)], nil
+}
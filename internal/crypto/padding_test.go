@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPaddingSize(t *testing.T) {
+	tests := []struct {
+		padding Padding
+		n, want int
+	}{
+		{PaddingNone, 1000, 1000},
+		{PaddingPadme, 9, 10},
+		{PaddingPadme, 100, 104},
+		{PaddingPadme, 1000, 1024},
+		{PaddingPadme, 1025, 1088},
+		{PaddingPow2, 9, 16},
+		{PaddingPow2, 1024, 1024},
+		{PaddingPow2, 1025, 2048},
+	}
+	for _, tt := range tests {
+		if got := tt.padding.Size(tt.n); got != tt.want {
+			t.Errorf("%s.Size(%d) = %d, want %d", tt.padding, tt.n, got, tt.want)
+		}
+	}
+
+	for _, p := range Paddings {
+		prev := 0
+		for n := 0; n < 5000; n++ {
+			size := p.Size(n)
+			if size < n || size < prev {
+				t.Fatalf("%s.Size(%d) = %d, after %d", p, n, size, prev)
+			}
+			if p == PaddingPadme && n > 0 && float64(size) > float64(n)*1.12 {
+				t.Fatalf("%s.Size(%d) = %d costs more than 12%%", p, n, size)
+			}
+			prev = size
+		}
+	}
+}
+
+func TestPad(t *testing.T) {
+	data := []byte("some compressed content")
+	for _, p := range Paddings {
+		padded := pad(p, data)
+		if len(padded) != p.Size(lengthSize+len(data)) {
+			t.Errorf("%s: padded to %d bytes", p, len(padded))
+		}
+		got, err := unpad(padded)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: got %q, %v", p, got, err)
+		}
+	}
+
+	padded := pad(PaddingPow2, data)
+	padded[lengthSize-1] = byte(len(padded))
+	if _, err := unpad(padded); err == nil {
+		t.Error("expected an error for a length past the end")
+	}
+	if _, err := PaddingByName("random"); err == nil {
+		t.Error("expected an error for an unknown padding")
+	}
+}
@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Suite is an AEAD cipher that file content and the metadata store can be
+// sealed with. Its ID is recorded in the header of everything it seals, so
+// a repository can mix suites.
+type Suite struct {
+	ID        byte
+	Name      string
+	NonceSize int
+	newAEAD   func(key []byte) (cipher.AEAD, error)
+}
+
+var (
+	// AES256GCM is the default, and fastest on CPUs with AES instructions.
+	AES256GCM = &Suite{ID: 1, Name: "aes-256-gcm", NonceSize: NonceSize, newAEAD: newGCM}
+	// XChaCha20Poly1305 is faster on CPUs without AES instructions, and its
+	// 24-byte nonces can be picked at random without any practical limit.
+	XChaCha20Poly1305 = &Suite{ID: 2, Name: "xchacha20-poly1305", NonceSize: chacha20poly1305.NonceSizeX, newAEAD: chacha20poly1305.NewX}
+)
+
+// Suites lists the supported cipher suites.
+var Suites = []*Suite{AES256GCM, XChaCha20Poly1305}
+
+// ErrUnknownSuite is returned for a cipher suite that doesn't exist.
+var ErrUnknownSuite = errors.New("unknown cipher suite")
+
+// SuiteByName returns the suite called name.
+func SuiteByName(name string) (*Suite, error) {
+	for _, suite := range Suites {
+		if suite.Name == name {
+			return suite, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownSuite, name)
+}
+
+func suiteByID(id byte) (*Suite, error) {
+	for _, suite := range Suites {
+		if suite.ID == id {
+			return suite, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %d", ErrUnknownSuite, id)
+}
+
+// AEAD returns the cipher for key.
+func (s *Suite) AEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("invalid key size")
+	}
+	return s.newAEAD(key)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
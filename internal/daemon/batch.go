@@ -0,0 +1,572 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"git-fs/internal/crypto"
+	"git-fs/internal/fileattr"
+	filemetadata "git-fs/internal/filemetadata"
+	fileutils "git-fs/internal/fileutil"
+	"git-fs/internal/gitutils"
+	"git-fs/internal/journal"
+	"git-fs/internal/logging"
+	"git-fs/internal/metrics"
+	"git-fs/internal/pack"
+	"git-fs/internal/status"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// recoverJournal rolls a committed batch forward and discards a partial one,
+// so that every batch is applied to the repository completely or not at all.
+func (d *Daemon) recoverJournal() error {
+	logger := logging.Logger
+
+	batch, err := d.journal.Pending()
+	if err != nil || batch == nil {
+		return err
+	}
+
+	if batch.State != journal.StateCommitted {
+		logger.Warn("Rolling back incomplete batch", zap.String("batch", batch.ID), zap.Int("files", len(batch.Sources)))
+		if err := batch.Rollback(); err != nil {
+			return err
+		}
+		// Its changes are still to be backed up.
+		if len(batch.Sources) > 0 {
+			d.requeue(batch.Sources)
+			d.batchTimer.Reset(d.config().Debounce)
+		}
+		return nil
+	}
+
+	logger.Info("Replaying committed batch", zap.String("batch", batch.ID), zap.Int("ops", len(batch.Ops)))
+	if err := batch.Apply(); err != nil {
+		return err
+	}
+	message := batch.Message
+	if message == "" {
+		message = recoveredCommitMessage
+	}
+	if err := commitChanges(d.repoPath, message); err != nil {
+		return err
+	}
+	return batch.Finish()
+}
+
+// handleChanges encrypts changedFiles into a single journaled batch, commits
+// it and schedules a push if a remote is configured.
+func (d *Daemon) handleChanges(changedFiles []string) error {
+	logger := logging.Logger
+	cfg := d.config()
+
+	// A batch whose git commit failed is still in the journal; retry it first.
+	if err := d.recoverJournal(); err != nil {
+		logger.Error("Failed to recover journal", zap.Error(err))
+		return err
+	}
+
+	batch, err := d.journal.Begin(changedFiles)
+	if err != nil {
+		logger.Error("Failed to begin batch", zap.Error(err))
+		return err
+	}
+
+	timer := prometheus.NewTimer(metrics.BatchDuration)
+	defer timer.ObserveDuration()
+
+	// Changes are made to a copy so the live store only sees committed batches.
+	next := d.metadataStore.Clone()
+
+	var packs *packer
+	if d.pack {
+		packs = d.newPacker(batch)
+	}
+
+	// Stat everything up front so progress can be reported against totals.
+	infos := make([]os.FileInfo, len(changedFiles))
+	var batchBytes int64
+	for i, f := range changedFiles {
+		if info, err := fileutils.SafeStat(f); err == nil {
+			infos[i] = info
+			if !info.IsDir() {
+				batchBytes += info.Size()
+			}
+		}
+	}
+
+	started := time.Now()
+	d.status.Update(func(st *status.Status) {
+		st.FilesPending = len(changedFiles)
+		st.BatchFiles = len(changedFiles)
+		st.BatchBytes = batchBytes
+		st.BatchFilesDone = 0
+		st.BatchBytesDone = 0
+		st.BatchStarted = started
+	})
+	defer d.status.Update(func(st *status.Status) {
+		st.BatchStarted = time.Time{}
+		st.CurrentFile = ""
+		st.CurrentBytes = 0
+	})
+
+	var info CommitInfo
+	var unchanged int
+
+	// Files that disappeared are only deleted at the end, so that a new path
+	// with identical content can take over their entry as a move.
+	gone := make(map[string]filemetadata.FileMetadata)
+	for i, f := range changedFiles {
+		if infos[i] != nil {
+			continue
+		}
+		relPath, _ := filepath.Rel(cfg.WatchPath, f)
+		if encName, metadata, ok := next.FindByPath(relPath); ok {
+			gone[encName] = metadata
+			continue
+		}
+		// A directory removed or moved away takes its files along, and
+		// the watcher may only have reported the directory itself.
+		for _, encName := range next.FindUnder(relPath) {
+			gone[encName] = next.Metadata[encName]
+		}
+	}
+
+	files := make([]*pendingFile, len(changedFiles))
+	for i, f := range changedFiles {
+		relPath, _ := filepath.Rel(cfg.WatchPath, f)
+		files[i] = &pendingFile{path: f, relPath: relPath, info: infos[i]}
+	}
+	moves := moveKeys(gone)
+
+	// Files are read and encrypted concurrently, but their results are
+	// recorded in order, so the batch comes out as if done one by one.
+	d.pipeline(files, func(p *pendingFile) {
+		if p.info != nil && !p.info.IsDir() {
+			d.prepareFile(batch, packs != nil, next, moves, p)
+		}
+	}, func(p *pendingFile) {
+		var size int64
+		if p.info != nil && !p.info.IsDir() {
+			size = p.info.Size()
+			d.status.Update(func(st *status.Status) {
+				st.CurrentFile = p.relPath
+				st.CurrentBytes = size
+			})
+			switch d.stageFile(batch, packs, next, gone, p) {
+			case stageWritten:
+				info.Written++
+				info.Bytes += size
+				info.Paths = append(info.Paths, p.relPath)
+			case stageMoved:
+				info.Moved++
+				info.Paths = append(info.Paths, p.relPath)
+			case stageUpdated:
+				info.Updated++
+				info.Paths = append(info.Paths, p.relPath)
+			case stageUnchanged:
+				unchanged++
+			}
+		}
+
+		d.status.Update(func(st *status.Status) {
+			st.FilesPending--
+			st.BatchFilesDone++
+			st.BatchBytesDone += size
+			if elapsed := time.Since(started).Seconds(); elapsed > 0 {
+				st.BytesPerSecond = float64(st.BatchBytesDone) / elapsed
+			}
+		})
+	})
+
+	for encName, metadata := range gone {
+		removeObject(batch, next, encName)
+		info.Deleted++
+		info.Paths = append(info.Paths, metadata.OriginalPath)
+	}
+	sort.Strings(info.Paths)
+
+	if packs != nil {
+		if err := packs.flush(); err != nil {
+			logger.Error("Failed to write pack", zap.Error(err))
+			batch.Rollback()
+			return err
+		}
+	}
+
+	// A batch of moves, attribute changes or deletions of packed files only
+	// changes the metadata.
+	if batch.Empty() && info.Moved == 0 && info.Updated == 0 && info.Deleted == 0 {
+		// Nothing to commit, but keep the modification times refreshed for
+		// unchanged files so they aren't read again; they are saved with the
+		// next batch that commits.
+		if unchanged > 0 {
+			d.metadataStore.Replace(next)
+		}
+		return batch.Rollback()
+	}
+
+	batch.Message = d.commitMessage(info)
+	if err := d.applyBatch(batch, next); err != nil {
+		return err
+	}
+
+	// Add both encrypted files and metadata to git
+	if err := commitChanges(d.repoPath, batch.Message); err == nil {
+		d.lastCommit = time.Now()
+		metrics.Commits.WithLabelValues(metrics.ResultSuccess).Inc()
+		d.updateMetadataMetrics()
+		hash, cerr := gitutils.GetLastCommitHash(d.repoPath)
+		files, bytes := d.metadataStore.Totals()
+		d.status.Update(func(st *status.Status) {
+			if cerr == nil {
+				st.LastCommitHash = hash
+				st.LastCommitTime = time.Now()
+			}
+			st.FilesPending = 0
+			st.FilesProtected = files
+			st.BytesProtected = bytes
+		})
+	} else {
+		metrics.Commits.WithLabelValues(metrics.ResultFailure).Inc()
+		logger.Error("Git commit failed", zap.Error(err))
+		return errors.New("git commit failed; ensure you have a valid repo and permissions")
+	}
+
+	// The batch is only forgotten once it is committed to git
+	if err := batch.Finish(); err != nil {
+		logger.Warn("Failed to remove journal", zap.Error(err))
+	}
+
+	// Pushing is left to the push loop, which batches commits and retries failures.
+	d.requestPush()
+	return nil
+}
+
+// applyBatch stages next as the new metadata store, commits batch to the
+// journal and applies it to the repository, leaving the git commit to the
+// caller. The batch is rolled back if it fails before the journal commit.
+func (d *Daemon) applyBatch(batch *journal.Batch, next *filemetadata.MetadataStore) error {
+	logger := logging.Logger
+
+	// Stage metadata; committing the journal afterwards makes the batch durable
+	next.Counter++
+	if err := next.SaveToFile(batch.MetadataPath(), d.keys, d.suite); err != nil {
+		logger.Error("Failed to save metadata", zap.Error(err))
+		batch.Rollback()
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		logger.Error("Failed to commit batch", zap.Error(err))
+		batch.Rollback()
+		return err
+	}
+
+	d.metadataStore.Replace(next)
+
+	if err := batch.Apply(); err != nil {
+		logger.Error("Failed to apply batch", zap.String("batch", batch.ID), zap.Error(err))
+		return err
+	}
+	if err := filemetadata.RecordCounter(d.counterPath(), next.Counter); err != nil {
+		logger.Warn("Failed to record metadata counter", zap.Error(err))
+	}
+	return nil
+}
+
+// stageResult is what stageFile did with a changed file.
+type stageResult int
+
+const (
+	stageFailed stageResult = iota
+	stageWritten
+	stageMoved
+	stageUpdated
+	stageUnchanged
+)
+
+// racyWindow is how much older than the time it was read a file's
+// modification time must be before it is trusted to detect changes. A
+// write within the same timestamp tick as the read would go unnoticed.
+const racyWindow = 2 * time.Second
+
+// pendingFile is a changed file on its way into a batch. prepareFile fills
+// in what can be done concurrently with other files; stageFile then records
+// the result in order.
+type pendingFile struct {
+	path    string
+	relPath string
+	info    os.FileInfo // Nil if the file is gone
+
+	attrs     fileattr.Attrs
+	unchanged bool // Size and modification time match; the content wasn't read
+	content   []byte
+	hash      string
+	err       error       // Reading failed
+	sealed    *sealedFile // Encrypted ahead, unless the file may be a move
+}
+
+// sealedFile is the encrypted form of a pendingFile.
+type sealedFile struct {
+	name      string
+	nameNonce []byte
+	blob      []byte
+	hash      string
+	staged    bool  // Written to the staging area, rather than left for a pack
+	err       error // Encryption failed; already logged
+}
+
+// moveKey identifies file content for move detection.
+type moveKey struct {
+	size int64
+	hash string
+}
+
+// moveKeys returns the content of the files in gone, which new paths with
+// the same content are moves of.
+func moveKeys(gone map[string]filemetadata.FileMetadata) map[moveKey]struct{} {
+	keys := make(map[moveKey]struct{}, len(gone))
+	for _, metadata := range gone {
+		keys[moveKey{metadata.FileSize, metadata.OriginalHash}] = struct{}{}
+	}
+	return keys
+}
+
+// prepareFile reads and fingerprints p and, unless it is unchanged or may
+// be a move of one of the files in moves, encrypts it. Only the entry for
+// p's own path is looked up in next, which no other file changes, so it is
+// safe to run concurrently for different files while stageFile records
+// earlier ones.
+func (d *Daemon) prepareFile(batch *journal.Batch, packed bool, next *filemetadata.MetadataStore, moves map[moveKey]struct{}, p *pendingFile) {
+	if p.attrs, p.err = fileattr.Read(p.path, p.info, d.config().PreserveOwnership); p.err != nil {
+		return
+	}
+
+	_, existing, known := next.FindByPath(p.relPath)
+	if known && existing.FileSize == p.info.Size() && !existing.ModTime.IsZero() && existing.LegacyHash == "" &&
+		existing.ModTime.Equal(p.info.ModTime()) && existing.ModTime.Before(existing.LastModified.Add(-racyWindow)) {
+		p.unchanged = true
+		return
+	}
+
+	// Read file content
+	if p.content, p.err = os.ReadFile(p.path); p.err != nil {
+		return
+	}
+
+	// Fingerprint the plaintext; keyed, since the metadata may leak
+	p.hash = crypto.ContentMAC(d.keys.MAC, p.content)
+
+	if known && sameContent(&existing, p) {
+		return
+	}
+	// Whether it is a move depends on the files before it; leave it to stageFile.
+	if _, ok := moves[moveKey{int64(len(p.content)), p.hash}]; ok {
+		return
+	}
+	p.sealed = d.sealFile(batch, packed, p)
+}
+
+// sameContent reports whether p, which was read, holds the content existing
+// was stored with. A plain hash from an older store is replaced in existing
+// by p's keyed one when it matches.
+func sameContent(existing *filemetadata.FileMetadata, p *pendingFile) bool {
+	if existing.FileSize == int64(len(p.content)) && existing.OriginalHash == p.hash {
+		return true
+	}
+	return existing.UpgradeHash(p.content, p.hash)
+}
+
+// stageFile records the current content of p in batch and next. A file
+// whose size and modification time, or else content, match what is stored
+// for its path is left alone, apart from its attributes. Content matching one of the files in gone is
+// taken to be that file moved to the new path, and only its metadata is
+// updated. Failures are logged and leave the previous version in place.
+func (d *Daemon) stageFile(batch *journal.Batch, packs *packer, next *filemetadata.MetadataStore, gone map[string]filemetadata.FileMetadata, p *pendingFile) stageResult {
+	logger := logging.Logger
+
+	if p.err != nil {
+		logger.Error("Failed to read file", zap.String("file", p.path), zap.Error(p.err))
+		d.recordError(p.err)
+		return stageFailed
+	}
+
+	encName, existing, known := next.FindByPath(p.relPath)
+	unchanged := p.unchanged || known && sameContent(&existing, p)
+	if unchanged && !existing.Attrs().Matches(p.attrs) {
+		logger.Info("File attributes changed", zap.String("file", p.path))
+		existing.SetAttrs(p.attrs)
+		existing.LastModified = time.Now()
+		next.Put(encName, existing)
+		metrics.FilesUpdated.Inc()
+		return stageUpdated
+	}
+
+	if p.unchanged {
+		logger.Debug("File unchanged", zap.String("file", p.path))
+		metrics.FilesUnchanged.Inc()
+		return stageUnchanged
+	}
+
+	// Touched or rewritten with the same bytes; only remember the new time.
+	if unchanged {
+		logger.Debug("File content unchanged", zap.String("file", p.path))
+		existing.ModTime = p.info.ModTime()
+		existing.LastModified = time.Now()
+		next.Put(encName, existing)
+		metrics.FilesUnchanged.Inc()
+		return stageUnchanged
+	}
+
+	if encName, ok := findMoved(gone, int64(len(p.content)), p.hash); ok {
+		metadata := gone[encName]
+		delete(gone, encName)
+
+		// A file moved over another one replaces it.
+		if oldName, _, ok := next.FindByPath(p.relPath); ok {
+			removeObject(batch, next, oldName)
+		}
+
+		logger.Info("File moved",
+			zap.String("from", metadata.OriginalPath),
+			zap.String("to", p.relPath))
+		metadata.OriginalPath = p.relPath
+		metadata.SetAttrs(p.attrs)
+		next.Put(encName, metadata)
+		metrics.FilesMoved.Inc()
+		return stageMoved
+	}
+
+	// Content like that of a file gone, which an earlier file turned out
+	// to be the move of, wasn't encrypted ahead.
+	sealed := p.sealed
+	if sealed == nil {
+		sealed = d.sealFile(batch, packs != nil, p)
+	}
+	if sealed.err != nil || !d.storeFile(batch, packs, next, p, sealed) {
+		return stageFailed
+	}
+	return stageWritten
+}
+
+// findMoved returns the entry in gone with the given size and content hash.
+func findMoved(gone map[string]filemetadata.FileMetadata, size int64, hash string) (string, bool) {
+	for encName, metadata := range gone {
+		if metadata.FileSize == size && metadata.OriginalHash == hash {
+			return encName, true
+		}
+	}
+	return "", false
+}
+
+// removeObject removes the entry for encName from next and its blob from
+// the repository. A packed blob stays in its pack until gc repacks it.
+func removeObject(batch *journal.Batch, next *filemetadata.MetadataStore, encName string) {
+	if next.Metadata[encName].Pack == "" {
+		batch.Delete(encName)
+	}
+	next.Remove(encName)
+}
+
+// sealFile encrypts the name and content of p. Unless packed is set and
+// the result is small enough for a pack, it is written to the staging area
+// of batch right away. Failures are logged and recorded in the result.
+func (d *Daemon) sealFile(batch *journal.Batch, packed bool, p *pendingFile) *sealedFile {
+	logger := logging.Logger
+	s := &sealedFile{}
+
+	// Generate encrypted filename
+	s.name, _, s.nameNonce, s.err = crypto.EncryptFileName(d.keys.Name, p.relPath)
+	if s.err != nil {
+		logger.Error("Failed to encrypt filename", zap.String("file", p.path), zap.Error(s.err))
+		d.recordError(s.err)
+		return s
+	}
+
+	// Encrypt file content
+	s.blob, s.err = crypto.SealBlob(d.keys, crypto.SealOptions{
+		Suite:       d.suite,
+		Compression: d.config().CompressionFor(p.relPath),
+		Padding:     d.padding,
+	}, s.name, p.content)
+	if s.err != nil {
+		logger.Error("Failed to encrypt file",
+			zap.String("file", p.path),
+			zap.Error(s.err))
+		d.recordError(s.err)
+		return s
+	}
+
+	// Calculate encrypted hash
+	s.hash = calculateHash(s.blob)
+
+	// Stage encrypted content; it is moved into .encrypted once the batch commits
+	if !packed || len(s.blob) > pack.MaxObjectSize {
+		stagePath := batch.StagePath(s.name)
+		if s.err = fileutils.WriteFileAtomic(stagePath, s.blob, 0600); s.err != nil {
+			logger.Error("Failed to write encrypted file",
+				zap.String("path", stagePath),
+				zap.Error(s.err))
+			d.recordError(s.err)
+			return s
+		}
+		s.staged = true
+	}
+	return s
+}
+
+// storeFile adds the encrypted file s to batch, or to a pack, and records
+// its metadata in next. Failures are logged; the result reports whether p
+// was stored.
+func (d *Daemon) storeFile(batch *journal.Batch, packs *packer, next *filemetadata.MetadataStore, p *pendingFile, s *sealedFile) bool {
+	logger := logging.Logger
+
+	var packName string
+	if s.staged {
+		batch.Write(s.name)
+	} else {
+		var err error
+		if packName, err = packs.add(s.name, s.blob); err != nil {
+			logger.Error("Failed to pack encrypted file",
+				zap.String("file", p.path),
+				zap.Error(err))
+			d.recordError(err)
+			return false
+		}
+	}
+
+	// The previous version of the file is replaced, not kept alongside
+	if oldName, _, ok := next.FindByPath(p.relPath); ok {
+		removeObject(batch, next, oldName)
+	}
+
+	// Update metadata
+	metadata := filemetadata.FileMetadata{
+		EncryptedName:   s.name,
+		OriginalPath:    p.relPath,
+		OriginalHash:    p.hash,
+		EncryptedHash:   s.hash,
+		LastModified:    time.Now(),
+		FileSize:        int64(len(p.content)),
+		EncryptionNonce: s.nameNonce,
+		KeyVersion:      crypto.KeyVersionSubkeys,
+		BlobFormat:      crypto.BlobFormatV4,
+		Pack:            packName,
+	}
+	metadata.SetAttrs(p.attrs)
+	next.Put(s.name, metadata)
+
+	metrics.FilesEncrypted.Inc()
+	metrics.BytesIn.Add(float64(len(p.content)))
+	metrics.BytesOut.Add(float64(len(s.blob)))
+
+	logger.Info("File encrypted",
+		zap.String("file", p.path),
+		zap.String("encrypted", s.name))
+	return true
+}
@@ -0,0 +1,412 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git-fs/internal/config"
+	"git-fs/internal/crypto"
+	filemetadata "git-fs/internal/filemetadata"
+	"git-fs/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// newTestDaemon returns a daemon for a fresh repository and watch directory.
+func newTestDaemon(t *testing.T) (*Daemon, string) {
+	t.Helper()
+	logging.Logger = zap.NewNop()
+
+	repo := t.TempDir()
+	watch := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "user.name", "test"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	d, err := New(&config.Config{
+		Password:      "password",
+		RepoPath:      repo,
+		WatchPath:     watch,
+		CommitMessage: config.DefaultCommitMessage,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return d, watch
+}
+
+func commitCount(t *testing.T, d *Daemon) string {
+	t.Helper()
+	cmd := exec.Command("git", "rev-list", "--count", "HEAD")
+	cmd.Dir = d.repoPath
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git rev-list: %v", err)
+	}
+	return string(out)
+}
+
+func TestHandleChangesMoves(t *testing.T) {
+	d, watch := newTestDaemon(t)
+
+	src := filepath.Join(watch, "dir", "a.txt")
+	if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(src, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.handleChanges([]string{src}); err != nil {
+		t.Fatalf("handleChanges failed: %v", err)
+	}
+	encName, _, ok := d.metadataStore.FindByPath(filepath.Join("dir", "a.txt"))
+	if !ok {
+		t.Fatal("file not recorded")
+	}
+	blob, err := os.ReadFile(filepath.Join(d.repoPath, ".encrypted", encName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Rename keeps the blob", func(t *testing.T) {
+		dst := filepath.Join(watch, "dir", "b.txt")
+		if err := os.Rename(src, dst); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.handleChanges([]string{src, dst}); err != nil {
+			t.Fatalf("handleChanges failed: %v", err)
+		}
+
+		if _, _, ok := d.metadataStore.FindByPath(filepath.Join("dir", "a.txt")); ok {
+			t.Error("old path still recorded")
+		}
+		moved, _, ok := d.metadataStore.FindByPath(filepath.Join("dir", "b.txt"))
+		if !ok || moved != encName {
+			t.Fatalf("new path recorded as %q (found %v), want %q", moved, ok, encName)
+		}
+		after, err := os.ReadFile(filepath.Join(d.repoPath, ".encrypted", encName))
+		if err != nil || string(after) != string(blob) {
+			t.Errorf("blob was rewritten or removed: %v", err)
+		}
+		if got := commitCount(t, d); got != "2\n" {
+			t.Errorf("expected a commit for the move, have %s commits", got)
+		}
+	})
+
+	t.Run("Directory moved away and back in", func(t *testing.T) {
+		elsewhere := filepath.Join(t.TempDir(), "dir")
+		if err := os.Rename(filepath.Join(watch, "dir"), elsewhere); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(elsewhere, filepath.Join(watch, "renamed")); err != nil {
+			t.Fatal(err)
+		}
+		// Only the directories are reported, plus the files found in the new one.
+		changed := []string{filepath.Join(watch, "dir"), filepath.Join(watch, "renamed"), filepath.Join(watch, "renamed", "b.txt")}
+		if err := d.handleChanges(changed); err != nil {
+			t.Fatalf("handleChanges failed: %v", err)
+		}
+
+		moved, _, ok := d.metadataStore.FindByPath(filepath.Join("renamed", "b.txt"))
+		if !ok || moved != encName {
+			t.Errorf("moved directory not recorded as a move: %q, %v", moved, ok)
+		}
+		if files, _ := d.metadataStore.Totals(); files != 1 {
+			t.Errorf("expected 1 file, have %d", files)
+		}
+	})
+
+	t.Run("Move over an existing file replaces it", func(t *testing.T) {
+		other := filepath.Join(watch, "other.txt")
+		if err := os.WriteFile(other, []byte("other content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.handleChanges([]string{other}); err != nil {
+			t.Fatalf("handleChanges failed: %v", err)
+		}
+		otherName, _, _ := d.metadataStore.FindByPath("other.txt")
+
+		src := filepath.Join(watch, "renamed", "b.txt")
+		if err := os.Rename(src, other); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.handleChanges([]string{src, other}); err != nil {
+			t.Fatalf("handleChanges failed: %v", err)
+		}
+
+		moved, _, ok := d.metadataStore.FindByPath("other.txt")
+		if !ok || moved != encName {
+			t.Errorf("other.txt recorded as %q, want %q", moved, encName)
+		}
+		if _, err := os.Stat(filepath.Join(d.repoPath, ".encrypted", otherName)); !os.IsNotExist(err) {
+			t.Errorf("replaced blob still present: %v", err)
+		}
+		if files, _ := d.metadataStore.Totals(); files != 1 {
+			t.Errorf("expected 1 file, have %d", files)
+		}
+	})
+}
+
+func TestHandleChangesUnchanged(t *testing.T) {
+	d, watch := newTestDaemon(t)
+
+	path := filepath.Join(watch, "a.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.handleChanges([]string{path}); err != nil {
+		t.Fatalf("handleChanges failed: %v", err)
+	}
+	encName, _, _ := d.metadataStore.FindByPath("a.txt")
+
+	t.Run("Same size and time", func(t *testing.T) {
+		if err := d.handleChanges([]string{path}); err != nil {
+			t.Fatalf("handleChanges failed: %v", err)
+		}
+		if got := commitCount(t, d); got != "1\n" {
+			t.Errorf("expected no new commit, have %s commits", got)
+		}
+	})
+
+	t.Run("Rewritten with the same content", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.handleChanges([]string{path}); err != nil {
+			t.Fatalf("handleChanges failed: %v", err)
+		}
+		if got := commitCount(t, d); got != "1\n" {
+			t.Errorf("expected no new commit, have %s commits", got)
+		}
+		name, metadata, _ := d.metadataStore.FindByPath("a.txt")
+		if name != encName {
+			t.Errorf("file was encrypted again as %q", name)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !metadata.ModTime.Equal(info.ModTime()) {
+			t.Errorf("modification time not refreshed: %v, want %v", metadata.ModTime, info.ModTime())
+		}
+	})
+
+	t.Run("Changed content of the same size", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte("CONTENT"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.handleChanges([]string{path}); err != nil {
+			t.Fatalf("handleChanges failed: %v", err)
+		}
+		if got := commitCount(t, d); got != "2\n" {
+			t.Errorf("expected a new commit, have %s commits", got)
+		}
+	})
+
+	t.Run("Mode changed", func(t *testing.T) {
+		encName, _, _ := d.metadataStore.FindByPath("a.txt")
+		if err := os.Chmod(path, 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.handleChanges([]string{path}); err != nil {
+			t.Fatalf("handleChanges failed: %v", err)
+		}
+		if got := commitCount(t, d); got != "3\n" {
+			t.Errorf("expected a new commit, have %s commits", got)
+		}
+		name, metadata, _ := d.metadataStore.FindByPath("a.txt")
+		if name != encName {
+			t.Errorf("file was encrypted again as %q", name)
+		}
+		if metadata.Mode != 0750 {
+			t.Errorf("mode %v recorded, want %v", metadata.Mode, os.FileMode(0750))
+		}
+	})
+}
+
+func TestBlobsAndMetadataAreBound(t *testing.T) {
+	d, watch := newTestDaemon(t)
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(watch, name), []byte("content of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.handleChanges([]string{filepath.Join(watch, "a.txt"), filepath.Join(watch, "b.txt")}); err != nil {
+		t.Fatalf("handleChanges failed: %v", err)
+	}
+	older, err := os.ReadFile(d.metadataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(watch, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.handleChanges([]string{filepath.Join(watch, "a.txt")}); err != nil {
+		t.Fatalf("handleChanges failed: %v", err)
+	}
+
+	t.Run("Blobs open only as themselves", func(t *testing.T) {
+		_, a, _ := d.metadataStore.FindByPath("a.txt")
+		_, b, _ := d.metadataStore.FindByPath("b.txt")
+		blobA, err := os.ReadFile(filepath.Join(d.repoPath, ".encrypted", a.EncryptedName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content, err := a.Open(d.keys, blobA); err != nil || string(content) != "changed" {
+			t.Errorf("Open = %q, %v", content, err)
+		}
+		if _, err := b.Open(d.keys, blobA); err == nil {
+			t.Error("blob of a.txt opened as b.txt")
+		}
+	})
+
+	t.Run("Older metadata store is refused", func(t *testing.T) {
+		if err := os.WriteFile(d.metadataPath, older, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(d.config()); !errors.Is(err, filemetadata.ErrRollback) {
+			t.Errorf("expected ErrRollback, got %v", err)
+		}
+	})
+}
+
+func TestProcessPendingRetriesFailedBatch(t *testing.T) {
+	d, watch := newTestDaemon(t)
+
+	path := filepath.Join(watch, "a.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file where the journal directory belongs fails the batch early.
+	journal := filepath.Join(d.repoPath, journalDir)
+	if err := os.RemoveAll(journal); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(journal, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	d.requeue([]string{path})
+	if err := d.processPending(true); err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	if _, _, ok := d.metadataStore.FindByPath("a.txt"); ok {
+		t.Fatal("failed batch was recorded")
+	}
+
+	if err := os.Remove(journal); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.processPending(true); err != nil {
+		t.Fatalf("processPending failed: %v", err)
+	}
+	if _, _, ok := d.metadataStore.FindByPath("a.txt"); !ok {
+		t.Error("file of the failed batch was not retried")
+	}
+	if got := commitCount(t, d); got != "1\n" {
+		t.Errorf("expected a commit, have %s commits", got)
+	}
+}
+
+func TestRecoverRequeuesRolledBackBatch(t *testing.T) {
+	d, watch := newTestDaemon(t)
+
+	path := filepath.Join(watch, "a.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash after the batch began, before it committed.
+	batch, err := d.journal.Begin([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(batch.StagePath("partial"), []byte("partial blob"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restarted daemon rolls the batch back and backs up its files.
+	d, err = New(d.config())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := d.processPending(false); err != nil {
+		t.Fatalf("processPending failed: %v", err)
+	}
+	if _, _, ok := d.metadataStore.FindByPath("a.txt"); !ok {
+		t.Error("file of the rolled back batch was not backed up")
+	}
+	if got := commitCount(t, d); got != "1\n" {
+		t.Errorf("expected a commit, have %s commits", got)
+	}
+}
+
+func TestUpgradeKeepsUnchangedFiles(t *testing.T) {
+	d, watch := newTestDaemon(t)
+
+	content := []byte("content")
+	path := filepath.Join(watch, "a.txt")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.handleChanges([]string{path}); err != nil {
+		t.Fatalf("handleChanges failed: %v", err)
+	}
+	encName, _, _ := d.metadataStore.FindByPath("a.txt")
+
+	// Turn the store into one written before hashes were keyed.
+	legacy := d.metadataStore.Clone()
+	metadata := legacy.Metadata[encName]
+	hash := sha256.Sum256(content)
+	metadata.OriginalHash = base64.StdEncoding.EncodeToString(hash[:])
+	legacy.Put(encName, metadata)
+	legacy.Version = 0
+	if err := legacy.SaveToFile(d.metadataPath, d.keys, d.suite); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+
+	// The restarted daemon checks the file against the plain hash once.
+	d, err := New(d.config())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := d.processPending(false); err != nil {
+		t.Fatalf("processPending failed: %v", err)
+	}
+	if got := commitCount(t, d); got != "1\n" {
+		t.Errorf("expected no new commit, have %s commits", got)
+	}
+	name, metadata, _ := d.metadataStore.FindByPath("a.txt")
+	if name != encName {
+		t.Errorf("file was encrypted again as %q", name)
+	}
+	if want := crypto.ContentMAC(d.keys.MAC, content); metadata.OriginalHash != want {
+		t.Errorf("expected the keyed hash %q, got %q", want, metadata.OriginalHash)
+	}
+	if metadata.LegacyHash != "" {
+		t.Errorf("plain hash kept: %q", metadata.LegacyHash)
+	}
+}
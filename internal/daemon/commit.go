@@ -0,0 +1,85 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"git-fs/internal/config"
+	"git-fs/internal/gitutils"
+	"git-fs/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// recoveredCommitMessage is used for batches journaled before commit
+// messages were recorded in the journal.
+const recoveredCommitMessage = "Automated encrypted backup"
+
+// CommitInfo is the data available to the commit_message template.
+type CommitInfo struct {
+	Hostname string
+	Time     time.Time
+	Files    int // Files written, moved, updated or deleted
+	Written  int
+	Moved    int // Files renamed or moved without changing their content
+	Updated  int // Files whose mode, owner or extended attributes changed, but not their content
+	Deleted  int
+	Bytes    int64 // Plaintext bytes written
+
+	// Paths lists the changed plaintext paths. It is only filled in when
+	// commit_message_paths is enabled, since the message is not encrypted.
+	Paths []string
+}
+
+// commitMessage renders the configured commit message for info. A template
+// that fails to render falls back to the default one.
+func (d *Daemon) commitMessage(info CommitInfo) string {
+	cfg := d.config()
+
+	info.Hostname, _ = os.Hostname()
+	info.Time = time.Now()
+	info.Files = info.Written + info.Moved + info.Updated + info.Deleted
+	if !cfg.CommitMessagePaths {
+		info.Paths = nil
+	}
+
+	msg, err := renderCommitMessage(cfg.CommitMessage, info)
+	if err != nil {
+		logging.Logger.Warn("Failed to render commit_message, using the default", zap.Error(err))
+		msg, _ = renderCommitMessage(config.DefaultCommitMessage, info)
+	}
+	return msg
+}
+
+func renderCommitMessage(text string, info CommitInfo) (string, error) {
+	tmpl, err := template.New("commit_message").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, info); err != nil {
+		return "", err
+	}
+
+	msg := strings.TrimSpace(b.String())
+	if msg == "" {
+		return "", errors.New("commit message is empty")
+	}
+	return msg, nil
+}
+
+// commitChanges commits the working tree, treating an unchanged tree as success.
+func commitChanges(repoPath, message string) error {
+	changed, err := gitutils.HasChanges(repoPath)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	return gitutils.AddAndCommit(repoPath, message)
+}
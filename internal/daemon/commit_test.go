@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git-fs/internal/config"
+)
+
+func TestRenderCommitMessage(t *testing.T) {
+	info := CommitInfo{Hostname: "laptop", Files: 5, Written: 2, Moved: 1, Updated: 1, Deleted: 1, Paths: []string{"a.txt"}}
+
+	t.Run("Default template", func(t *testing.T) {
+		msg, err := renderCommitMessage(config.DefaultCommitMessage, info)
+		if err != nil {
+			t.Fatalf("renderCommitMessage failed: %v", err)
+		}
+		want := "Automated encrypted backup: 5 files (2 written, 1 moved, 1 updated, 1 deleted) on laptop"
+		if msg != want {
+			t.Errorf("got %q, want %q", msg, want)
+		}
+	})
+
+	t.Run("Paths", func(t *testing.T) {
+		msg, err := renderCommitMessage("{{.Files}} changed{{range .Paths}}\n{{.}}{{end}}", info)
+		if err != nil {
+			t.Fatalf("renderCommitMessage failed: %v", err)
+		}
+		if msg != "5 changed\na.txt" {
+			t.Errorf("unexpected message %q", msg)
+		}
+	})
+
+	t.Run("Empty message is rejected", func(t *testing.T) {
+		if _, err := renderCommitMessage("{{range .Paths}}{{end}}", CommitInfo{}); err == nil {
+			t.Error("expected an error for an empty message")
+		}
+	})
+
+	t.Run("Unknown field is rejected", func(t *testing.T) {
+		if _, err := renderCommitMessage("{{.Author}}", info); err == nil {
+			t.Error("expected an error for an unknown field")
+		}
+	})
+}
+
+func TestBatchLen(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a", "b", "c", "d"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	missing := filepath.Join(dir, "deleted")
+
+	tests := []struct {
+		name     string
+		files    []string
+		maxFiles int
+		maxBytes int64
+		want     int
+	}{
+		{"No limits", files, 0, 0, 4},
+		{"File limit", files, 3, 0, 3},
+		{"Byte limit", files, 0, 250, 2},
+		{"Both limits", files, 1, 250, 1},
+		{"Oversized file still makes a batch", files, 0, 50, 1},
+		{"Deleted files count as empty", append([]string{missing}, files...), 0, 200, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := batchLen(tt.files, tt.maxFiles, tt.maxBytes); got != tt.want {
+				t.Errorf("batchLen = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,293 +1,652 @@
 package daemon
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
-
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
-
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"git-fs/internal/config"
+	"git-fs/internal/control"
 	"git-fs/internal/crypto"
 	filemetadata "git-fs/internal/filemetadata"
 	fileutils "git-fs/internal/fileutil"
 	"git-fs/internal/gitutils"
+	"git-fs/internal/journal"
+	"git-fs/internal/lock"
 	"git-fs/internal/logging"
+	"git-fs/internal/metrics"
+	"git-fs/internal/sdnotify"
 	"git-fs/internal/status"
+	"git-fs/internal/watcher"
 
-	"github.com/fsnotify/fsnotify"
+	"github.com/robfig/cron/v3"
 	"go.uber.org/zap"
 )
 
+// journalDir holds the batch journal and its staged files, relative to the repo.
+const journalDir = ".journal"
+
+// Default locations of the pidfile and the log of a detached daemon, relative to the repo.
+const (
+	PIDFileName = ".git-fs.pid"
+	LogFileName = ".git-fs.log"
+)
+
+// statusFileName is where the daemon publishes its status, relative to the repo.
+const statusFileName = ".status.json"
+
+// statusSaveInterval limits how often the status file is rewritten.
+const statusSaveInterval = time.Second
+
+// localState lists repository files that must never be committed.
+var localState = []string{
+	statusFileName, // Holds plaintext paths of files in progress
+	journalDir + "/",
+	lock.FileName,
+	lock.TakeoverFileName,
+	PIDFileName,
+	LogFileName,
+	control.SocketName,
+	filemetadata.CounterFileName,
+	".*.tmp-*", // Left behind by atomic writes interrupted by a crash
+}
+
 func calculateHash(data []byte) string {
 	hash := sha256.Sum256(data)
 	return base64.StdEncoding.EncodeToString(hash[:])
 }
 
-func RunDaemon(cfg *config.Config) error {
+// Daemon watches WatchPath and turns changes into encrypted commits in RepoPath.
+type Daemon struct {
+	cfgMu sync.RWMutex
+	cfg   *config.Config
+
+	repoPath      string
+	metadataPath  string
+	keys          *crypto.Keys
+	suite         *crypto.Suite  // What new files and metadata are encrypted with
+	padding       crypto.Padding // What new files are padded with
+	pack          bool           // Whether small files are bundled into packs
+	journal       *journal.Journal
+	metadataStore *filemetadata.MetadataStore
+	status        *status.Tracker
+	changes       *filemetadata.ChangeSet
+
+	// batchMu serializes handleChanges between the debounce loop, control
+	// requests and shutdown.
+	batchMu sync.Mutex
+	paused  atomic.Bool
+	// batchTimer fires when the pending changes are due, see enqueue.
+	batchTimer *time.Timer
+	// lastCommit is when handleChanges last committed; guarded by batchMu.
+	lastCommit time.Time
+	// batchFailures counts batches failed in a row; guarded by batchMu.
+	batchFailures int
+	// wake triggers processing of pending changes outside the batch timer.
+	wake chan struct{}
+
+	// rescanDirs collects directories the watcher lost events for.
+	rescanMu   sync.Mutex
+	rescanDirs map[string]struct{}
+	rescanKick chan struct{}
+
+	// pushMu serializes pushes between the push loop and control requests.
+	pushMu   sync.Mutex
+	pushKick chan struct{}
+}
+
+// New derives the repository key, recovers any interrupted batch and loads
+// the metadata store.
+func New(cfg *config.Config) (*Daemon, error) {
 	logger := logging.Logger
 
 	saltPath := filepath.Join(cfg.RepoPath, ".salt")
 	salt, err := fileutils.ReadOrCreateSalt(saltPath)
 	if err != nil {
 		logger.Error("Failed to get or create salt", zap.String("path", saltPath), zap.Error(err))
-		return errors.New("could not initialize encryption salt; please check permissions or run `git-fs init` first")
+		return nil, errors.New("could not initialize encryption salt; please check permissions or run `git-fs init` first")
 	}
 
 	key, err := crypto.DeriveKey(cfg.Password, salt)
 	if err != nil {
 		logger.Error("Failed to derive key", zap.Error(err))
-		return errors.New("invalid password or salt; cannot derive encryption key")
+		return nil, errors.New("invalid password or salt; cannot derive encryption key")
+	}
+
+	keys, err := crypto.DeriveKeys(key)
+	if err != nil {
+		logger.Error("Failed to derive subkeys", zap.Error(err))
+		return nil, err
+	}
+
+	settings, err := config.LoadRepoSettings(cfg.RepoPath)
+	if err != nil {
+		logger.Error("Failed to load repository settings", zap.Error(err))
+		return nil, fmt.Errorf("could not load %s: %w", config.RepoSettingsFileName, err)
 	}
 
-	// Load or create metadata store
 	metadataPath := filepath.Join(cfg.RepoPath, ".metadata.enc")
-	metadataStore, err := filemetadata.LoadMetadataStore(metadataPath, key)
+	d := &Daemon{
+		cfg:          cfg,
+		repoPath:     cfg.RepoPath,
+		metadataPath: metadataPath,
+		keys:         keys,
+		suite:        settings.Suite(),
+		padding:      settings.PaddingScheme(),
+		pack:         settings.Pack,
+		journal:      journal.New(filepath.Join(cfg.RepoPath, journalDir), filepath.Join(cfg.RepoPath, ".encrypted"), metadataPath),
+		status:       status.NewTracker(filepath.Join(cfg.RepoPath, statusFileName), statusSaveInterval),
+		changes:      &filemetadata.ChangeSet{Files: make(map[string]struct{})},
+		batchTimer:   time.NewTimer(0),
+		wake:         make(chan struct{}, 1),
+		rescanDirs:   make(map[string]struct{}),
+		rescanKick:   make(chan struct{}, 1),
+		pushKick:     make(chan struct{}, 1),
+	}
+	d.batchTimer.Stop()
+
+	for _, pattern := range localState {
+		if err := gitutils.EnsureExcluded(cfg.RepoPath, pattern); err != nil {
+			logger.Warn("Failed to exclude local state from git", zap.String("pattern", pattern), zap.Error(err))
+		}
+	}
+	// Older versions committed the status file; stop tracking it.
+	if err := gitutils.Untrack(cfg.RepoPath, statusFileName); err != nil {
+		logger.Warn("Failed to untrack status file", zap.Error(err))
+	}
+
+	// Finish or discard whatever batch a previous run left behind before the
+	// metadata store is loaded, since rolling forward replaces it.
+	if err := d.recoverJournal(); err != nil {
+		logger.Error("Failed to recover journal", zap.Error(err))
+		return nil, errors.New("could not recover the previous batch; check the journal in the repository")
+	}
+
+	// Load or create metadata store
+	d.metadataStore, err = filemetadata.LoadMetadataStore(metadataPath, keys)
 	if err != nil {
 		logger.Error("Failed to load metadata store", zap.Error(err))
-		return errors.New("could not load metadata store")
+		return nil, errors.New("could not load metadata store")
+	}
+	if err := filemetadata.RecordCounter(d.counterPath(), d.metadataStore.Counter); err != nil {
+		logger.Error("Metadata store failed the rollback check", zap.Error(err))
+		if errors.Is(err, filemetadata.ErrRollback) {
+			return nil, fmt.Errorf("%w; if an older version was restored on purpose, remove %s", err, d.counterPath())
+		}
+		return nil, err
+	}
+
+	// Plain hashes from an older store aren't saved again; read those files
+	// before they are lost, so that unchanged ones aren't encrypted anew.
+	if legacy := d.metadataStore.LegacyPaths(); len(legacy) > 0 {
+		logger.Info("Checking files against hashes from an older metadata store", zap.Int("files", len(legacy)))
+		for i, rel := range legacy {
+			legacy[i] = filepath.Join(cfg.WatchPath, rel)
+		}
+		d.requeue(legacy)
+		d.batchTimer.Reset(cfg.Debounce)
 	}
 
-	watcher, err := fsnotify.NewWatcher()
+	return d, nil
+}
+
+// counterPath is where the newest metadata counter seen is remembered.
+func (d *Daemon) counterPath() string {
+	return filepath.Join(d.repoPath, filemetadata.CounterFileName)
+}
+
+// RunDaemon runs a daemon for cfg until ctx is cancelled.
+func RunDaemon(ctx context.Context, cfg *config.Config) error {
+	d, err := New(cfg)
 	if err != nil {
-		logger.Error("Failed to create watcher", zap.Error(err))
-		return errors.New("could not create file watcher")
+		return err
 	}
-	defer watcher.Close()
+	return d.Run(ctx)
+}
 
-	if err = watcher.Add(cfg.WatchPath); err != nil {
-		logger.Error("Failed to add watch path", zap.String("watchPath", cfg.WatchPath), zap.Error(err))
-		return errors.New("could not watch the specified directory; please check if it exists and is accessible")
+func (d *Daemon) config() *config.Config {
+	d.cfgMu.RLock()
+	defer d.cfgMu.RUnlock()
+	return d.cfg
+}
+
+// Reload re-reads the configuration. Settings that identify the repository
+// or the key cannot change while running and are kept until a restart.
+func (d *Daemon) Reload() error {
+	logger := logging.Logger
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		logger.Error("Failed to reload config", zap.Error(err))
+		return err
 	}
 
-	st := &status.Status{
-		WatcherRunning: true,
+	sdnotify.Notify(sdnotify.Reloading)
+	defer sdnotify.Notify(sdnotify.Ready)
+
+	d.cfgMu.Lock()
+	defer d.cfgMu.Unlock()
+
+	if cfg.Password != d.cfg.Password || cfg.RepoPath != d.cfg.RepoPath || cfg.WatchPath != d.cfg.WatchPath ||
+		cfg.MetricsListen != d.cfg.MetricsListen || cfg.Watch != d.cfg.Watch || cfg.Schedule != d.cfg.Schedule ||
+		cfg.Watcher != d.cfg.Watcher || cfg.PollInterval != d.cfg.PollInterval {
+		logger.Warn("Password, repo_path, watch_path, metrics_listen, watch, watcher, poll_interval and schedule changes take effect after a restart")
+		cfg.Password = d.cfg.Password
+		cfg.RepoPath = d.cfg.RepoPath
+		cfg.WatchPath = d.cfg.WatchPath
+		cfg.MetricsListen = d.cfg.MetricsListen
+		cfg.Watch = d.cfg.Watch
+		cfg.Schedule = d.cfg.Schedule
+		cfg.Watcher = d.cfg.Watcher
+		cfg.PollInterval = d.cfg.PollInterval
 	}
-	statusPath := filepath.Join(cfg.RepoPath, ".status.json")
-	status.SaveStatus(statusPath, st)
+	d.cfg = cfg
 
-	cs := &filemetadata.ChangeSet{Files: make(map[string]struct{})}
+	logger.Info("Configuration reloaded")
+	return nil
+}
+
+// Run watches for changes until ctx is cancelled. On cancellation it stops
+// accepting events, flushes the pending changes into a final commit and
+// marks the watcher as stopped.
+func (d *Daemon) Run(ctx context.Context) error {
+	logger := logging.Logger
+	cfg := d.config()
 
-	debounce := time.NewTimer(0)
-	if !debounce.Stop() {
-		<-debounce.C
+	if !cfg.Watch && cfg.Schedule == "" {
+		return errors.New("watch is disabled and no schedule is set; there is nothing to do")
 	}
 
-	done := make(chan bool)
+	var schedule cron.Schedule
+	if cfg.Schedule != "" {
+		var err error
+		if schedule, err = cron.ParseStandard(cfg.Schedule); err != nil {
+			logger.Error("Failed to parse schedule", zap.String("schedule", cfg.Schedule), zap.Error(err))
+			return errors.New("invalid schedule; expected a cron expression such as \"0 3 * * *\"")
+		}
+	}
 
-	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					logger.Info("Watcher events channel closed, stopping daemon.")
-					return
-				}
-				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
-					cs.Mu.Lock()
-					cs.Files[event.Name] = struct{}{}
-					cs.Mu.Unlock()
-
-					if !debounce.Stop() {
-						select {
-						case <-debounce.C:
-						default:
-						}
-					}
-					debounce.Reset(2 * time.Second)
-				}
+	// Without a watcher, changes are only picked up by scheduled snapshots.
+	var w watcher.Watcher
+	if cfg.Watch {
+		var err error
+		w, err = watcher.New(cfg.Watcher, cfg.WatchPath, cfg.PollInterval)
+		if err != nil {
+			logger.Error("Failed to watch path", zap.String("watchPath", cfg.WatchPath), zap.String("watcher", cfg.Watcher), zap.Error(err))
+			return errors.New("could not watch the specified directory; please check if it exists and is accessible")
+		}
+		defer w.Close()
+		logger.Info("Watching for changes", zap.String("watchPath", cfg.WatchPath), zap.String("watcher", w.Kind()))
+		d.status.Update(func(st *status.Status) { st.Watcher = w.Kind() })
+	}
 
-			case werr, ok := <-watcher.Errors:
-				if !ok {
-					logger.Warn("Watcher errors channel closed")
-					return
-				}
-				logger.Error("Watcher error occurred", zap.Error(werr))
+	files, bytes := d.metadataStore.Totals()
+	d.status.Update(func(st *status.Status) {
+		st.WatcherRunning = true
+		st.StartedAt = time.Now()
+		st.FilesProtected = files
+		st.BytesProtected = bytes
+	})
+
+	socketPath := filepath.Join(d.repoPath, control.SocketName)
+	server, err := control.Listen(socketPath, d)
+	if err != nil {
+		// The daemon still works without it; only live status and control are lost.
+		logger.Warn("Failed to start control API", zap.String("socket", socketPath), zap.Error(err))
+	} else {
+		defer server.Close()
+		go func() {
+			if err := server.Serve(); err != nil {
+				logger.Error("Control API stopped", zap.Error(err))
 			}
+		}()
+	}
+
+	if cfg.MetricsListen != "" {
+		metricsServer, err := metrics.Listen(cfg.MetricsListen)
+		if err != nil {
+			logger.Error("Failed to start metrics endpoint", zap.String("listen", cfg.MetricsListen), zap.Error(err))
+			return errors.New("could not listen on metrics_listen; check that the address is valid and free")
 		}
+		defer metricsServer.Close()
+		go func() {
+			if err := metricsServer.Serve(); err != nil {
+				logger.Error("Metrics endpoint stopped", zap.Error(err))
+			}
+		}()
+		logger.Info("Serving metrics", zap.String("listen", cfg.MetricsListen))
+	}
+	d.updateMetadataMetrics()
+
+	sdnotify.Notify(sdnotify.Ready)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.pushLoop(ctx)
 	}()
 
-	// debounce goroutine to include metadata handling
+	if w != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.rescanLoop(ctx)
+		}()
+	}
+
+	if schedule != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.runSchedule(ctx, schedule)
+		}()
+	}
+
+	// batch goroutine to include metadata handling
+	wg.Add(1)
 	go func() {
+		defer wg.Done()
 		for {
-			<-debounce.C
-			cs.Mu.Lock()
-			changedFiles := make([]string, 0, len(cs.Files))
-			for f := range cs.Files {
-				changedFiles = append(changedFiles, f)
-			}
-			cs.Files = make(map[string]struct{})
-			cs.Mu.Unlock()
-
-			if len(changedFiles) > 0 {
-				logger.Info("Processing changes", zap.Int("file_count", len(changedFiles)))
-				if err := handleChanges(cfg, key, changedFiles, st, statusPath, metadataStore, metadataPath); err != nil {
-					logger.Error("Failed to handle changes", zap.Error(err))
-				}
+			select {
+			case <-ctx.Done():
+				return
+			case <-d.batchTimer.C:
+				d.processPending(false)
+			case <-d.wake:
+				d.processPending(false)
 			}
 		}
 	}()
 
-	<-done
+	d.watch(ctx, w)
+	cancel()
+
+	logger.Info("Shutting down daemon")
+	sdnotify.Notify(sdnotify.Stopping)
+	if w != nil {
+		w.Close()
+	}
+	d.batchTimer.Stop()
+	wg.Wait()
+
+	// Flush whatever arrived after the last debounce fired, even when paused,
+	// and make one last attempt to get the commits off this machine. An
+	// unreachable remote mustn't hold up the shutdown past the service
+	// manager's patience.
+	d.processPending(true)
+	pushCtx, cancelPush := context.WithTimeout(context.Background(), shutdownPushTimeout)
+	err = d.push(pushCtx)
+	cancelPush()
+	if err != nil {
+		logger.Warn("Commits remain unpushed; they are retried on the next start", zap.Error(err))
+	}
+
+	d.status.Update(func(st *status.Status) {
+		st.WatcherRunning = false
+		st.QueueDepth = 0
+	})
+	if err := d.status.Save(); err != nil {
+		logger.Warn("Failed to save status on shutdown", zap.Error(err))
+	}
+	logger.Info("Daemon stopped")
 	return nil
 }
 
-func handleChanges(cfg *config.Config, key []byte, changedFiles []string, st *status.Status,
-	statusPath string, metadataStore *filemetadata.MetadataStore, metadataPath string) error {
+// watch collects events into the change set until ctx is cancelled or the
+// watcher closes. A nil watcher just waits for ctx.
+func (d *Daemon) watch(ctx context.Context, w watcher.Watcher) {
 	logger := logging.Logger
-	encryptedRoot := filepath.Join(cfg.RepoPath, ".encrypted")
 
-	st.FilesPending = len(changedFiles)
-	if err := status.SaveStatus(statusPath, st); err != nil {
-		logger.Warn("Failed to save status before encryption", zap.Error(err))
+	var events <-chan string
+	var errs <-chan error
+	if w != nil {
+		events, errs = w.Events(), w.Errors()
 	}
 
-	for _, f := range changedFiles {
-		fileInfo, err := fileutils.SafeStat(f)
-		if err != nil {
-			// Handle deleted files
-			relPath, _ := filepath.Rel(cfg.WatchPath, f)
-			metadataStore.Mu.Lock()
-			for encName, metadata := range metadataStore.Metadata {
-				if metadata.OriginalPath == relPath {
-					encPath := filepath.Join(encryptedRoot, encName)
-					if fileutils.FileExists(encPath) {
-						if removeErr := os.Remove(encPath); removeErr != nil {
-							logger.Warn("Failed to remove encrypted file",
-								zap.String("path", encPath), zap.Error(removeErr))
-						}
-					}
-					delete(metadataStore.Metadata, encName)
-					break
-				}
-			}
-			metadataStore.Mu.Unlock()
-			st.FilesPending--
-			status.SaveStatus(statusPath, st)
-			continue
-		}
+	// The watchdog is pinged from this loop rather than the batch loop, since
+	// a large batch may legitimately take longer than the watchdog timeout.
+	var watchdog <-chan time.Time
+	if interval, ok := sdnotify.WatchdogInterval(); ok {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
 
-		if !fileInfo.IsDir() {
-			// Read file content
-			content, err := os.ReadFile(f)
-			if err != nil {
-				logger.Error("Failed to read file", zap.String("file", f), zap.Error(err))
-				st.FilesPending--
-				status.SaveStatus(statusPath, st)
-				continue
-			}
+	for {
+		select {
+		case <-ctx.Done():
+			return
 
-			// Calculate original hash
-			originalHash := calculateHash(content)
+		case <-watchdog:
+			sdnotify.Notify(sdnotify.Watchdog)
 
-			// Generate encrypted filename
-			relPath, _ := filepath.Rel(cfg.WatchPath, f)
-			encryptedName, fileNonce, nameNonce, err := crypto.EncryptFileName(key, relPath)
-			if err != nil {
-				logger.Error("Failed to encrypt filename", zap.String("file", f), zap.Error(err))
-				st.FilesPending--
-				status.SaveStatus(statusPath, st)
-				continue
+		case path, ok := <-events:
+			if !ok {
+				logger.Info("Watcher events channel closed, stopping daemon.")
+				return
 			}
+			metrics.EventsReceived.Inc()
+			d.enqueue(path)
 
-			encPath := filepath.Join(encryptedRoot, encryptedName)
-			if err := fileutils.EnsureDir(filepath.Dir(encPath)); err != nil {
-				logger.Error("Failed to ensure directory",
-					zap.String("path", filepath.Dir(encPath)),
-					zap.Error(err))
-				st.FilesPending--
-				status.SaveStatus(statusPath, st)
-				continue
+		case werr, ok := <-errs:
+			if !ok {
+				logger.Warn("Watcher errors channel closed")
+				return
 			}
-
-			// Encrypt file content
-			encryptedContent, err := crypto.EncryptFile(key, content, fileNonce)
-			if err != nil {
-				logger.Error("Failed to encrypt file",
-					zap.String("file", f),
-					zap.String("encrypted_path", encPath),
-					zap.Error(err))
-				st.FilesPending--
-				status.SaveStatus(statusPath, st)
+			var rescan *watcher.RescanError
+			if errors.As(werr, &rescan) {
+				if errors.Is(rescan.Err, watcher.ErrUnwatched) {
+					// Routine rescan of a directory reported before.
+					logger.Debug("Rescanning unwatched directory", zap.String("path", rescan.Path))
+				} else {
+					logger.Warn("Watcher lost events, rescanning", zap.String("path", rescan.Path), zap.Error(rescan.Err))
+					d.recordError(werr)
+				}
+				d.requestRescan(rescan.Path)
 				continue
 			}
+			logger.Error("Watcher error occurred", zap.Error(werr))
+			d.recordError(werr)
+		}
+	}
+}
 
-			// Calculate encrypted hash
-			encryptedHash := calculateHash(encryptedContent)
+// enqueue adds paths to the pending change set and reschedules the batch
+// timer: after the debounce interval, but no later than max_batch_wait after
+// the oldest pending change, and right away once max_batch_files is reached.
+func (d *Daemon) enqueue(paths ...string) {
+	cfg := d.config()
 
-			// Save encrypted content
-			if err := os.WriteFile(encPath, encryptedContent, 0600); err != nil {
-				logger.Error("Failed to write encrypted file",
-					zap.String("path", encPath),
-					zap.Error(err))
-				st.FilesPending--
-				status.SaveStatus(statusPath, st)
-				continue
-			}
+	d.changes.Mu.Lock()
+	if len(d.changes.Files) == 0 {
+		d.changes.Since = time.Now()
+	}
+	for _, p := range paths {
+		d.changes.Files[p] = struct{}{}
+	}
+	depth := len(d.changes.Files)
+	since := d.changes.Since
+	d.changes.Mu.Unlock()
+	d.status.Update(func(st *status.Status) { st.QueueDepth = depth })
+
+	delay := cfg.Debounce
+	if cfg.MaxBatchWait > 0 {
+		delay = min(delay, time.Until(since.Add(cfg.MaxBatchWait)))
+	}
+	if cfg.MaxBatchFiles > 0 && depth >= cfg.MaxBatchFiles {
+		delay = 0
+	}
+	d.batchTimer.Reset(max(delay, 0))
+}
+
+// takeChanges empties the pending change set and returns its paths in order.
+func (d *Daemon) takeChanges() []string {
+	d.changes.Mu.Lock()
+	changedFiles := make([]string, 0, len(d.changes.Files))
+	for f := range d.changes.Files {
+		changedFiles = append(changedFiles, f)
+	}
+	d.changes.Files = make(map[string]struct{})
+	d.changes.Mu.Unlock()
+	d.status.Update(func(st *status.Status) { st.QueueDepth = 0 })
+
+	sort.Strings(changedFiles)
+	return changedFiles
+}
+
+// requeue puts paths that were taken but not processed back into the
+// pending change set, without touching the batch timer.
+func (d *Daemon) requeue(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
 
-			// Update metadata
-			metadata := filemetadata.FileMetadata{
-				EncryptedName:   encryptedName,
-				OriginalPath:    relPath,
-				OriginalHash:    originalHash,
-				EncryptedHash:   encryptedHash,
-				LastModified:    time.Now(),
-				FileSize:        fileInfo.Size(),
-				EncryptionNonce: nameNonce,
-				FileNonce:       fileNonce,
+	d.changes.Mu.Lock()
+	if len(d.changes.Files) == 0 {
+		d.changes.Since = time.Now()
+	}
+	for _, p := range paths {
+		d.changes.Files[p] = struct{}{}
+	}
+	depth := len(d.changes.Files)
+	d.changes.Mu.Unlock()
+	d.status.Update(func(st *status.Status) { st.QueueDepth = depth })
+}
+
+// processPending takes the accumulated change set and handles it in batches
+// of at most max_batch_files files and max_batch_bytes bytes. While paused,
+// changes keep accumulating, and commits are spaced by min_commit_interval;
+// force overrides both.
+func (d *Daemon) processPending(force bool) error {
+	logger := logging.Logger
+	cfg := d.config()
+
+	d.batchMu.Lock()
+	defer d.batchMu.Unlock()
+
+	if d.paused.Load() && !force {
+		return nil
+	}
+
+	changedFiles := d.takeChanges()
+	for len(changedFiles) > 0 {
+		if !force {
+			if wait := time.Until(d.lastCommit.Add(cfg.MinCommitInterval)); wait > 0 {
+				logger.Debug("Holding back changes until min_commit_interval has passed", zap.Duration("wait", wait))
+				d.requeue(changedFiles)
+				d.batchTimer.Reset(wait)
+				return nil
 			}
+		}
 
-			metadataStore.Mu.Lock()
-			metadataStore.Metadata[encryptedName] = metadata
-			metadataStore.Mu.Unlock()
-
-			logger.Info("File encrypted",
-				zap.String("file", f),
-				zap.String("encrypted", encPath))
-			st.FilesPending--
-			status.SaveStatus(statusPath, st)
-		} else {
-			st.FilesPending--
-			status.SaveStatus(statusPath, st)
+		n := batchLen(changedFiles, cfg.MaxBatchFiles, cfg.MaxBatchBytes)
+		logger.Info("Processing changes", zap.Int("file_count", n), zap.Int("remaining", len(changedFiles)-n))
+		if err := d.handleChanges(changedFiles[:n]); err != nil {
+			// Keep everything, the failed batch included, for a retry that
+			// backs off like a failing push.
+			d.batchFailures++
+			retry := pushBackoff(d.batchFailures, cfg.PushBackoffMax)
+			logger.Error("Failed to handle changes, will retry",
+				zap.Int("failures", d.batchFailures),
+				zap.Duration("retry_in", retry),
+				zap.Error(err))
+			d.recordError(err)
+			d.requeue(changedFiles)
+			d.batchTimer.Reset(retry)
+			return err
 		}
+		d.batchFailures = 0
+		changedFiles = changedFiles[n:]
 	}
+	return nil
+}
 
-	// Save metadata before git operations
-	if err := metadataStore.SaveToFile(metadataPath, key); err != nil {
-		logger.Error("Failed to save metadata", zap.Error(err))
-		return err
+// batchLen returns how many of files make up the next batch under the
+// maxFiles and maxBytes limits, where zero means no limit. A batch always
+// takes at least one file, however large.
+func batchLen(files []string, maxFiles int, maxBytes int64) int {
+	n := len(files)
+	if maxFiles > 0 && n > maxFiles {
+		n = maxFiles
+	}
+	if maxBytes <= 0 {
+		return n
 	}
 
-	// Add both encrypted files and metadata to git
-	if err := gitutils.AddAndCommit(cfg.RepoPath, "Automated encrypted backup"); err == nil {
-		if hash, cerr := gitutils.GetLastCommitHash(cfg.RepoPath); cerr == nil {
-			st.LastCommitHash = hash
-			st.LastCommitTime = time.Now()
+	var total int64
+	for i, f := range files[:n] {
+		if info, err := fileutils.SafeStat(f); err == nil && !info.IsDir() {
+			total += info.Size()
 		}
-		st.FilesPending = 0
-		status.SaveStatus(statusPath, st)
-	} else {
-		logger.Error("Git commit failed", zap.Error(err))
-		return errors.New("git commit failed; ensure you have a valid repo and permissions")
+		if total > maxBytes && i > 0 {
+			return i
+		}
+	}
+	return n
+}
+
+// updateMetadataMetrics publishes the size of the metadata store.
+func (d *Daemon) updateMetadataMetrics() {
+	files, _ := d.metadataStore.Totals()
+	metrics.MetadataEntries.Set(float64(files))
+	if info, err := os.Stat(d.metadataPath); err == nil {
+		metrics.MetadataBytes.Set(float64(info.Size()))
 	}
+}
 
-	if cfg.RemoteURL != "" {
-		if err := gitutils.Push(cfg.RepoPath, "origin", "main"); err != nil {
-			logger.Error("Failed to push to remote",
-				zap.String("remote_url", cfg.RemoteURL),
-				zap.Error(err))
-			return errors.New("failed to push to remote repository; check your network or remote configuration")
-		} else {
-			st.LastPushSuccessful = true
-			st.LastPushTime = time.Now()
-			status.SaveStatus(statusPath, st)
-			logger.Info("Changes pushed to remote",
-				zap.String("remote_url", cfg.RemoteURL))
-		}
+// recordError publishes err as the most recent error in the status.
+func (d *Daemon) recordError(err error) {
+	d.status.Update(func(st *status.Status) {
+		st.LastError = err.Error()
+		st.LastErrorTime = time.Now()
+	})
+}
+
+// Status returns a snapshot of the daemon status for the control API.
+func (d *Daemon) Status() status.Status {
+	return d.status.Snapshot()
+}
+
+// Pause stops processing batches; changes keep accumulating until Resume.
+func (d *Daemon) Pause() {
+	d.paused.Store(true)
+	d.status.Update(func(st *status.Status) { st.Paused = true })
+	logging.Logger.Info("Daemon paused")
+}
+
+// Resume processes the changes accumulated while paused.
+func (d *Daemon) Resume() {
+	d.paused.Store(false)
+	d.status.Update(func(st *status.Status) { st.Paused = false })
+	logging.Logger.Info("Daemon resumed")
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
 	}
+}
 
-	return nil
+// Flush processes pending changes immediately, even while paused.
+func (d *Daemon) Flush() error {
+	return d.processPending(true)
+}
+
+// Sync flushes pending changes and pushes without waiting.
+func (d *Daemon) Sync() error {
+	if err := d.Flush(); err != nil {
+		return err
+	}
+	return d.push(context.Background())
 }
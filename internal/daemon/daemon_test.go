@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git-fs/internal/status"
+	"git-fs/internal/watcher"
+)
+
+func TestRunShutdown(t *testing.T) {
+	d, watch := newTestDaemon(t)
+	cfg := d.config()
+	cfg.Watch = true
+	cfg.Watcher = watcher.KindFSNotify
+	cfg.PollInterval = time.Second
+	cfg.Debounce = time.Hour // Only the shutdown gets to the change
+	cfg.PushRetryInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !d.Status().WatcherRunning {
+		if time.Now().After(deadline) {
+			t.Fatal("watcher did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	path := filepath.Join(watch, "a.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.enqueue(path)
+	if depth := d.Status().QueueDepth; depth != 1 {
+		t.Fatalf("expected a queued change, have %d", depth)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+
+	if got := commitCount(t, d); got != "1\n" {
+		t.Errorf("expected the queued change to be committed, have %s commits", got)
+	}
+	if _, _, ok := d.metadataStore.FindByPath("a.txt"); !ok {
+		t.Error("queued file was not backed up")
+	}
+	st, err := status.LoadStatus(filepath.Join(d.repoPath, statusFileName))
+	if err != nil {
+		t.Fatalf("LoadStatus failed: %v", err)
+	}
+	if st.WatcherRunning {
+		t.Error("status still shows the watcher running")
+	}
+	if st.QueueDepth != 0 {
+		t.Errorf("expected an empty queue, have %d", st.QueueDepth)
+	}
+}
@@ -0,0 +1,187 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git-fs/internal/logging"
+	"git-fs/internal/metrics"
+	"git-fs/internal/pack"
+
+	"go.uber.org/zap"
+)
+
+// GC reclaims space in the object directory and commits the result. Packs
+// that are mostly garbage, or small, are repacked together, and in pack mode
+// small blobs stored on their own are packed as well. Packs and blobs no
+// file refers to any more are removed.
+func (d *Daemon) GC() error {
+	logger := logging.Logger
+
+	d.batchMu.Lock()
+	defer d.batchMu.Unlock()
+
+	if err := d.recoverJournal(); err != nil {
+		logger.Error("Failed to recover journal", zap.Error(err))
+		return err
+	}
+
+	batch, err := d.journal.Begin(nil)
+	if err != nil {
+		logger.Error("Failed to begin batch", zap.Error(err))
+		return err
+	}
+
+	objectDir := filepath.Join(d.repoPath, ".encrypted")
+	entries, err := os.ReadDir(objectDir)
+	if err != nil && !os.IsNotExist(err) {
+		batch.Rollback()
+		return err
+	}
+
+	next := d.metadataStore.Clone()
+	live := make(map[string][]string) // Pack name to the objects in it
+	for encName, metadata := range next.Metadata {
+		if metadata.Pack != "" {
+			live[metadata.Pack] = append(live[metadata.Pack], encName)
+		}
+	}
+
+	// Find what is worth rewriting before deciding whether to.
+	reader := pack.NewReader(objectDir, d.keys)
+	var repack, loose []string
+	var sparse bool
+	var removed int
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		if pack.IsName(name) {
+			ids, ok := live[name]
+			if !ok {
+				batch.Delete(name)
+				removed++
+				continue
+			}
+			p, err := reader.Pack(name)
+			if err != nil {
+				logger.Error("Failed to read pack, leaving it alone", zap.String("pack", name), zap.Error(err))
+				d.recordError(err)
+				continue
+			}
+			var liveBytes int64
+			for _, id := range ids {
+				blob, err := p.Blob(id)
+				if err != nil {
+					logger.Error("Object missing from pack", zap.String("pack", name), zap.String("object", id), zap.Error(err))
+					batch.Rollback()
+					return err
+				}
+				liveBytes += int64(len(blob))
+			}
+			// Padding isn't waste that repacking could reclaim.
+			switch size := p.BlobBytes(); {
+			case liveBytes*2 < size:
+				sparse = true
+				repack = append(repack, name)
+			case size*2 < pack.MaxSize:
+				repack = append(repack, name)
+			}
+			continue
+		}
+
+		metadata, ok := next.Metadata[name]
+		if !ok || metadata.Pack != "" {
+			batch.Delete(name)
+			removed++
+			continue
+		}
+		if info, err := entry.Info(); d.pack && err == nil && info.Size() <= pack.MaxObjectSize {
+			loose = append(loose, name)
+		}
+	}
+
+	// Rewriting a single small pack on its own gains nothing.
+	if !sparse && len(repack)+len(loose) < 2 {
+		repack, loose = nil, nil
+	}
+
+	packs := d.newPacker(batch)
+	move := func(id string, blob []byte) error {
+		packName, err := packs.add(id, blob)
+		if err != nil {
+			return err
+		}
+		metadata := next.Metadata[id]
+		metadata.Pack = packName
+		next.Put(id, metadata)
+		return nil
+	}
+	for _, name := range repack {
+		p, err := reader.Pack(name)
+		if err == nil {
+			for _, id := range live[name] {
+				blob, _ := p.Blob(id)
+				if err = move(id, blob); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			logger.Error("Failed to repack", zap.String("pack", name), zap.Error(err))
+			batch.Rollback()
+			return err
+		}
+		batch.Delete(name)
+	}
+	for _, name := range loose {
+		blob, err := os.ReadFile(filepath.Join(objectDir, name))
+		if err == nil {
+			err = move(name, blob)
+		}
+		if err != nil {
+			logger.Error("Failed to pack object", zap.String("object", name), zap.Error(err))
+			batch.Rollback()
+			return err
+		}
+		batch.Delete(name)
+	}
+	if err := packs.flush(); err != nil {
+		logger.Error("Failed to write pack", zap.Error(err))
+		batch.Rollback()
+		return err
+	}
+
+	if batch.Empty() {
+		logger.Info("Nothing to collect")
+		return batch.Rollback()
+	}
+
+	batch.Message = fmt.Sprintf("Garbage collection: %d packs rewritten, %d files packed, %d unused objects removed",
+		len(repack), len(loose), removed)
+	if err := d.applyBatch(batch, next); err != nil {
+		return err
+	}
+	if err := commitChanges(d.repoPath, batch.Message); err != nil {
+		metrics.Commits.WithLabelValues(metrics.ResultFailure).Inc()
+		logger.Error("Git commit failed", zap.Error(err))
+		return errors.New("git commit failed; ensure you have a valid repo and permissions")
+	}
+	metrics.Commits.WithLabelValues(metrics.ResultSuccess).Inc()
+	d.updateMetadataMetrics()
+	if err := batch.Finish(); err != nil {
+		logger.Warn("Failed to remove journal", zap.Error(err))
+	}
+
+	logger.Info("Garbage collection complete",
+		zap.Int("packs_rewritten", len(repack)),
+		zap.Int("files_packed", len(loose)),
+		zap.Int("removed", removed))
+	d.requestPush()
+	return nil
+}
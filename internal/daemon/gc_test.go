@@ -0,0 +1,139 @@
+package daemon
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git-fs/internal/pack"
+)
+
+// objectNames lists the object directory of d.
+func objectNames(t *testing.T, d *Daemon) (packs, loose []string) {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(d.repoPath, ".encrypted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if pack.IsName(entry.Name()) {
+			packs = append(packs, entry.Name())
+		} else {
+			loose = append(loose, entry.Name())
+		}
+	}
+	return packs, loose
+}
+
+// checkContent decrypts every file in the metadata store of d and compares
+// it to the file in watch.
+func checkContent(t *testing.T, d *Daemon, watch string) {
+	t.Helper()
+	objects := pack.NewReader(filepath.Join(d.repoPath, ".encrypted"), d.keys)
+	for encName, metadata := range d.metadataStore.Metadata {
+		blob, err := objects.Blob(encName, metadata.Pack)
+		if err != nil {
+			t.Fatalf("%s: %v", metadata.OriginalPath, err)
+		}
+		content, err := metadata.Open(d.keys, blob)
+		if err != nil {
+			t.Fatalf("%s: %v", metadata.OriginalPath, err)
+		}
+		want, _ := os.ReadFile(filepath.Join(watch, metadata.OriginalPath))
+		if !bytes.Equal(content, want) {
+			t.Errorf("%s: content differs", metadata.OriginalPath)
+		}
+	}
+}
+
+func TestPackAndGC(t *testing.T) {
+	d, watch := newTestDaemon(t)
+
+	// Written before pack mode was turned on.
+	big := filepath.Join(watch, "big")
+	if err := os.WriteFile(big, bytes.Repeat([]byte{0}, pack.MaxObjectSize+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unpacked := filepath.Join(watch, "unpacked")
+	if err := os.WriteFile(unpacked, []byte("unpacked"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.handleChanges([]string{big, unpacked}); err != nil {
+		t.Fatalf("handleChanges failed: %v", err)
+	}
+
+	d.pack = true
+	var files []string
+	for i := range 2 {
+		var batch []string
+		for _, name := range []string{"a", "b", "c"} {
+			path := filepath.Join(watch, strings.Repeat(name, i+1))
+			if err := os.WriteFile(path, []byte("content of "+path), 0644); err != nil {
+				t.Fatal(err)
+			}
+			batch = append(batch, path)
+		}
+		if err := d.handleChanges(batch); err != nil {
+			t.Fatalf("handleChanges failed: %v", err)
+		}
+		files = append(files, batch...)
+	}
+
+	packs, loose := objectNames(t, d)
+	if len(packs) != 2 || len(loose) != 2 {
+		t.Fatalf("expected a pack per batch and 2 loose objects, have %v and %v", packs, loose)
+	}
+	checkContent(t, d, watch)
+
+	t.Run("Deleting a packed file keeps its pack", func(t *testing.T) {
+		if err := os.Remove(files[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.handleChanges(files[:1]); err != nil {
+			t.Fatalf("handleChanges failed: %v", err)
+		}
+		if packs, _ := objectNames(t, d); len(packs) != 2 {
+			t.Errorf("expected 2 packs, have %v", packs)
+		}
+	})
+
+	t.Run("GC repacks", func(t *testing.T) {
+		before := commitCount(t, d)
+		if err := d.GC(); err != nil {
+			t.Fatalf("GC failed: %v", err)
+		}
+		packs, loose := objectNames(t, d)
+		if len(packs) != 1 || len(loose) != 1 {
+			t.Errorf("expected 1 pack and the big object, have %v and %v", packs, loose)
+		}
+		if commitCount(t, d) == before {
+			t.Error("expected a commit")
+		}
+		checkContent(t, d, watch)
+	})
+
+	t.Run("GC removes unused objects", func(t *testing.T) {
+		stray := filepath.Join(d.repoPath, ".encrypted", "stray")
+		if err := os.WriteFile(stray, []byte("stray"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.GC(); err != nil {
+			t.Fatalf("GC failed: %v", err)
+		}
+		if _, err := os.Stat(stray); !os.IsNotExist(err) {
+			t.Errorf("unused object still present: %v", err)
+		}
+	})
+
+	t.Run("GC with nothing to do", func(t *testing.T) {
+		before := commitCount(t, d)
+		if err := d.GC(); err != nil {
+			t.Fatalf("GC failed: %v", err)
+		}
+		if got := commitCount(t, d); got != before {
+			t.Errorf("expected no commit, have %s commits", got)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"git-fs/internal/crypto"
+	fileutils "git-fs/internal/fileutil"
+	"git-fs/internal/journal"
+	"git-fs/internal/pack"
+)
+
+// packer bundles the small blobs of a batch into pack files staged in it.
+type packer struct {
+	batch   *journal.Batch
+	keys    *crypto.Keys
+	suite   *crypto.Suite
+	padding crypto.Padding
+	w       *pack.Writer // The pack being filled, if any
+
+	// err is the first failure to stage a pack. Entries may already refer
+	// to that pack, so the batch must not commit.
+	err error
+}
+
+func (d *Daemon) newPacker(batch *journal.Batch) *packer {
+	return &packer{batch: batch, keys: d.keys, suite: d.suite, padding: d.padding}
+}
+
+// add appends the blob of object id to the current pack, staging that pack
+// first if the blob doesn't fit, and returns the name of the pack it is in.
+func (p *packer) add(id string, blob []byte) (string, error) {
+	if p.w != nil && !p.w.Fits(len(blob)) {
+		if err := p.flush(); err != nil {
+			return "", err
+		}
+	}
+	if p.w == nil {
+		name, err := pack.NewName()
+		if err != nil {
+			return "", err
+		}
+		p.w = pack.NewWriter(name)
+	}
+	p.w.Add(id, blob)
+	return p.w.Name, nil
+}
+
+// flush stages the current pack, if any, in the batch. It fails if any
+// pack of the batch failed to stage.
+func (p *packer) flush() error {
+	if p.err != nil || p.w == nil {
+		return p.err
+	}
+	w := p.w
+	p.w = nil
+
+	data, err := w.Finish(p.keys, p.suite, p.padding)
+	if err == nil {
+		err = fileutils.WriteFileAtomic(p.batch.StagePath(w.Name), data, 0600)
+	}
+	if err != nil {
+		p.err = err
+		return err
+	}
+	p.batch.Write(w.Name)
+	return nil
+}
@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"runtime"
+	"sync"
+)
+
+// pipeline calls prepare for files on up to concurrency workers and stage
+// for each file in order, as soon as it and those before it are prepared.
+// A file holds its share of memory_budget from before it is prepared until
+// it is staged, so that large files don't run concurrently. A file larger
+// than the whole budget runs on its own.
+func (d *Daemon) pipeline(files []*pendingFile, prepare, stage func(*pendingFile)) {
+	cfg := d.config()
+	workers := cfg.Concurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	budget := newMemoryBudget(cfg.MemoryBudget)
+
+	costs := make([]int64, len(files))
+	done := make([]chan struct{}, len(files))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	// Budget is taken in order, so the file stage waits for next always
+	// gets it before any file after it; nothing can deadlock.
+	work := make(chan int)
+	go func() {
+		defer close(work)
+		for i, p := range files {
+			costs[i] = budget.acquire(memoryCost(p))
+			work <- i
+		}
+	}()
+	for range min(workers, len(files)) {
+		go func() {
+			for i := range work {
+				prepare(files[i])
+				close(done[i])
+			}
+		}()
+	}
+
+	for i, p := range files {
+		<-done[i]
+		stage(p)
+		// Drop the content before the next files are let in.
+		p.content, p.sealed = nil, nil
+		budget.release(costs[i])
+	}
+}
+
+// memoryCost estimates the memory p takes while in the pipeline: its
+// content and the encrypted copy.
+func memoryCost(p *pendingFile) int64 {
+	if p.info == nil || p.info.IsDir() {
+		return 0
+	}
+	return 2 * p.info.Size()
+}
+
+// memoryBudget is a counting semaphore over bytes.
+type memoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64 // Zero means no limit
+	used  int64
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	b := &memoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire waits until n bytes are available, or the whole budget if n is
+// larger, takes them and returns how much was taken.
+func (b *memoryBudget) acquire(n int64) int64 {
+	if b.limit <= 0 {
+		return 0
+	}
+	n = min(n, b.limit)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	b.used += n
+	return n
+}
+
+// release returns n bytes taken by acquire.
+func (b *memoryBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
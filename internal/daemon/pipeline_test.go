@@ -0,0 +1,120 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	b := newMemoryBudget(100)
+	if got := b.acquire(60); got != 60 {
+		t.Fatalf("acquire(60) = %d", got)
+	}
+
+	acquired := make(chan int64)
+	go func() { acquired <- b.acquire(500) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquired more than is left")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.release(60)
+	if got := <-acquired; got != 100 {
+		t.Errorf("a file larger than the budget should take all of it, took %d", got)
+	}
+	b.release(100)
+
+	if got := newMemoryBudget(0).acquire(1 << 40); got != 0 {
+		t.Errorf("an unlimited budget took %d", got)
+	}
+}
+
+func TestPipelineOrder(t *testing.T) {
+	d, _ := newTestDaemon(t)
+	d.cfg.Concurrency = 4
+
+	files := make([]*pendingFile, 50)
+	for i := range files {
+		files[i] = &pendingFile{relPath: fmt.Sprint(i)}
+	}
+
+	var running, peak atomic.Int32
+	var staged []string
+	d.pipeline(files, func(p *pendingFile) {
+		n := running.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+	}, func(p *pendingFile) {
+		staged = append(staged, p.relPath)
+	})
+
+	for i, relPath := range staged {
+		if relPath != fmt.Sprint(i) {
+			t.Fatalf("staged out of order: %v", staged)
+		}
+	}
+	if len(staged) != len(files) {
+		t.Errorf("staged %d of %d files", len(staged), len(files))
+	}
+	if peak.Load() > 4 {
+		t.Errorf("%d files prepared at once, want at most 4", peak.Load())
+	}
+}
+
+func TestHandleChangesConcurrent(t *testing.T) {
+	d, watch := newTestDaemon(t)
+	d.cfg.Concurrency = 4
+	d.cfg.MemoryBudget = 1000
+
+	var changed []string
+	for i := range 40 {
+		path := filepath.Join(watch, fmt.Sprintf("f%02d", i))
+		if err := os.WriteFile(path, []byte(fmt.Sprint("content ", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		changed = append(changed, path)
+	}
+	big := filepath.Join(watch, "big")
+	if err := os.WriteFile(big, make([]byte, 5000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed = append(changed, big)
+	if err := d.handleChanges(changed); err != nil {
+		t.Fatalf("handleChanges failed: %v", err)
+	}
+	if files, _ := d.metadataStore.Totals(); files != 41 {
+		t.Fatalf("expected 41 files, have %d", files)
+	}
+	checkContent(t, d, watch)
+
+	// Two copies of a file that is gone: the first one in order is the
+	// move, the second one a new file.
+	encName, _, _ := d.metadataStore.FindByPath("f00")
+	if err := os.Rename(changed[0], filepath.Join(watch, "g1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(watch, "g2"), []byte("content 0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.handleChanges([]string{changed[0], filepath.Join(watch, "g1"), filepath.Join(watch, "g2")}); err != nil {
+		t.Fatalf("handleChanges failed: %v", err)
+	}
+	if moved, _, _ := d.metadataStore.FindByPath("g1"); moved != encName {
+		t.Errorf("g1 recorded as %q, want the moved %q", moved, encName)
+	}
+	if copied, _, ok := d.metadataStore.FindByPath("g2"); !ok || copied == encName {
+		t.Errorf("g2 recorded as %q", copied)
+	}
+	checkContent(t, d, watch)
+}
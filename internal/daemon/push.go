@@ -0,0 +1,172 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"git-fs/internal/gitutils"
+	"git-fs/internal/logging"
+	"git-fs/internal/metrics"
+	"git-fs/internal/status"
+
+	"go.uber.org/zap"
+)
+
+// shutdownPushTimeout bounds the last push when the daemon stops, well
+// within the TimeoutStopSec of the generated service.
+const shutdownPushTimeout = 30 * time.Second
+
+// pushBackoffInitial is the delay before the first retry of a failed push.
+const pushBackoffInitial = 15 * time.Second
+
+// requestPush tells the push loop that new commits are waiting.
+func (d *Daemon) requestPush() {
+	select {
+	case d.pushKick <- struct{}{}:
+	default:
+	}
+}
+
+// pushLoop pushes commits independently of file events. Requests arriving
+// within push_delay of each other are pushed together, failures are retried
+// with exponential backoff, and unpushed commits are checked for
+// periodically so that a machine that was offline catches up on its own.
+func (d *Daemon) pushLoop(ctx context.Context) {
+	logger := logging.Logger
+	cfg := d.config()
+
+	retry := time.NewTicker(cfg.PushRetryInterval)
+	defer retry.Stop()
+
+	var timer *time.Timer
+	var attempt <-chan time.Time
+	schedule := func(delay time.Duration) {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.NewTimer(delay)
+		attempt = timer.C
+		d.status.Update(func(st *status.Status) { st.NextPushAttempt = time.Now().Add(delay) })
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	// Commits left unpushed by a previous run go out right away.
+	if d.refreshUnpushed() > 0 {
+		schedule(0)
+	}
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-d.pushKick:
+			// While backing off, the pending retry picks up the new commits too.
+			if attempt == nil {
+				schedule(d.config().PushDelay)
+			}
+
+		case <-retry.C:
+			if attempt == nil && d.refreshUnpushed() > 0 {
+				schedule(0)
+			}
+
+		case <-attempt:
+			attempt = nil
+			d.status.Update(func(st *status.Status) { st.NextPushAttempt = time.Time{} })
+
+			if err := d.push(ctx); err != nil {
+				failures++
+				delay := pushBackoff(failures, d.config().PushBackoffMax)
+				logger.Warn("Push failed, retrying later",
+					zap.Int("failures", failures),
+					zap.Duration("retry_in", delay))
+				schedule(delay)
+				continue
+			}
+			failures = 0
+		}
+	}
+}
+
+// pushBackoff returns the delay before retry number failures: exponential
+// growth capped at maxDelay, with jitter so that many clients don't retry in step.
+func pushBackoff(failures int, maxDelay time.Duration) time.Duration {
+	delay := pushBackoffInitial
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	// Full jitter over the upper half keeps the delay growing between attempts.
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// refreshUnpushed publishes and returns the number of local commits not yet on the remote.
+func (d *Daemon) refreshUnpushed() int {
+	if d.config().RemoteURL == "" {
+		return 0
+	}
+
+	count, err := gitutils.UnpushedCount(d.repoPath, "origin", "main")
+	if err != nil {
+		logging.Logger.Warn("Failed to count unpushed commits", zap.Error(err))
+		return 0
+	}
+	metrics.UnpushedCommits.Set(float64(count))
+	d.status.Update(func(st *status.Status) { st.UnpushedCommits = count })
+	return count
+}
+
+// push sends local commits to the remote, if one is configured. All
+// unpushed commits go out in a single push, which is abandoned once ctx is
+// done.
+func (d *Daemon) push(ctx context.Context) error {
+	logger := logging.Logger
+	cfg := d.config()
+
+	if cfg.RemoteURL == "" {
+		return nil
+	}
+
+	d.pushMu.Lock()
+	defer d.pushMu.Unlock()
+
+	if d.refreshUnpushed() == 0 {
+		return nil
+	}
+
+	if err := gitutils.Push(ctx, d.repoPath, "origin", "main"); err != nil {
+		logger.Error("Failed to push to remote",
+			zap.String("remote_url", cfg.RemoteURL),
+			zap.Error(err))
+		metrics.Pushes.WithLabelValues(metrics.ResultFailure).Inc()
+		d.status.Update(func(st *status.Status) {
+			st.LastPushSuccessful = false
+			st.PushFailures++
+		})
+		d.recordError(err)
+		return errors.New("failed to push to remote repository; check your network or remote configuration")
+	}
+
+	now := time.Now()
+	metrics.Pushes.WithLabelValues(metrics.ResultSuccess).Inc()
+	metrics.LastSuccessfulPush.Set(float64(now.Unix()))
+	d.status.Update(func(st *status.Status) {
+		st.LastPushSuccessful = true
+		st.LastPushTime = now
+		st.PushFailures = 0
+	})
+	d.refreshUnpushed()
+	logger.Info("Changes pushed to remote",
+		zap.String("remote_url", cfg.RemoteURL))
+	return nil
+}
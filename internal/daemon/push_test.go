@@ -0,0 +1,37 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPushBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		maxDelay time.Duration
+		want     time.Duration // Before jitter
+	}{
+		{"First failure", 1, time.Hour, pushBackoffInitial},
+		{"Second failure", 2, time.Hour, 2 * pushBackoffInitial},
+		{"Fourth failure", 4, time.Hour, 8 * pushBackoffInitial},
+		{"Capped", 20, time.Hour, time.Hour},
+		{"Cap below the initial delay", 1, time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Jitter keeps the delay within the upper half of the backoff.
+			seen := make(map[time.Duration]bool)
+			for range 100 {
+				got := pushBackoff(tt.failures, tt.maxDelay)
+				if got < tt.want/2 || got > tt.want {
+					t.Fatalf("pushBackoff(%d, %v) = %v, want between %v and %v", tt.failures, tt.maxDelay, got, tt.want/2, tt.want)
+				}
+				seen[got] = true
+			}
+			if len(seen) == 1 {
+				t.Errorf("pushBackoff(%d, %v) has no jitter", tt.failures, tt.maxDelay)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package daemon
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"git-fs/internal/fileattr"
+	filemetadata "git-fs/internal/filemetadata"
+	"git-fs/internal/logging"
+	"git-fs/internal/metrics"
+	"git-fs/internal/status"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// Snapshot scans the whole watch path and commits everything that changed
+// since the last batch, including deletions nobody was watching for.
+func (d *Daemon) Snapshot() error {
+	logger := logging.Logger
+
+	changed, err := d.scan(d.config().WatchPath)
+	if err != nil {
+		logger.Error("Failed to scan watch path", zap.Error(err))
+		d.recordError(err)
+		return err
+	}
+	d.status.Update(func(st *status.Status) { st.LastSnapshot = time.Now() })
+	logger.Info("Snapshot scan complete", zap.Int("changed", len(changed)))
+
+	d.requeue(changed)
+	return d.processPending(true)
+}
+
+// scan walks dir, the watch path or a directory below it, and returns the
+// paths that differ from the metadata store: files that are new or were
+// modified after they were last encrypted, and files that are no longer there.
+func (d *Daemon) scan(dir string) ([]string, error) {
+	logger := logging.Logger
+	cfg := d.config()
+	root := cfg.WatchPath
+
+	prefix, _ := filepath.Rel(root, dir)
+	d.metadataStore.Mu.RLock()
+	missing := make(map[string]filemetadata.FileMetadata, len(d.metadataStore.Metadata))
+	for _, m := range d.metadataStore.Metadata {
+		if prefix != "." && !under(m.OriginalPath, []string{prefix}) {
+			continue
+		}
+		missing[m.OriginalPath] = m
+	}
+	d.metadataStore.Mu.RUnlock()
+
+	var changed []string
+	var unreadable []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				// A directory that is gone entirely only has deletions to report.
+				if dir != root && os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			// Skip what can't be read, but don't mistake it for deleted.
+			logger.Warn("Skipping unreadable path", zap.String("path", path), zap.Error(err))
+			rel, _ := filepath.Rel(root, path)
+			unreadable = append(unreadable, rel)
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		m, known := missing[rel]
+		delete(missing, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since it was listed; the next scan handles it.
+			return nil
+		}
+		if !known || info.Size() != m.FileSize || modified(m, info.ModTime()) || chmodded(m, info, cfg.PreserveOwnership) {
+			changed = append(changed, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for rel := range missing {
+		if !under(rel, unreadable) {
+			changed = append(changed, filepath.Join(root, rel))
+		}
+	}
+	return changed, nil
+}
+
+// modified reports whether a file last stored as m has been written since,
+// going by its modification time. Entries from before the time was recorded
+// fall back to comparing it with when the file was encrypted.
+func modified(m filemetadata.FileMetadata, modTime time.Time) bool {
+	if m.ModTime.IsZero() {
+		return modTime.After(m.LastModified)
+	}
+	return !modTime.Equal(m.ModTime)
+}
+
+// chmodded reports whether the mode or owner of a file stored as m has
+// changed, which leaves its modification time alone. Extended attributes
+// are only compared once the file is read. Entries from before attributes
+// were recorded are left to be updated with the next change.
+func chmodded(m filemetadata.FileMetadata, info fs.FileInfo, withOwner bool) bool {
+	if m.Mode == 0 {
+		return false
+	}
+	attrs := fileattr.FromInfo(info, withOwner)
+	attrs.Xattrs = m.Xattrs
+	return !m.Attrs().Matches(attrs)
+}
+
+// under reports whether rel is one of dirs or inside one of them.
+func under(rel string, dirs []string) bool {
+	for _, dir := range dirs {
+		if rel == dir || strings.HasPrefix(rel, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// runSchedule takes a snapshot at every activation of schedule until ctx is
+// cancelled. Activations while paused are skipped.
+func (d *Daemon) runSchedule(ctx context.Context, schedule cron.Schedule) {
+	logger := logging.Logger
+
+	for {
+		next := schedule.Next(time.Now())
+		d.status.Update(func(st *status.Status) { st.NextSnapshot = next })
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if d.paused.Load() {
+			logger.Info("Skipping scheduled snapshot while paused")
+			continue
+		}
+		logger.Info("Taking scheduled snapshot")
+		d.Snapshot()
+	}
+}
+
+// requestRescan queues dir for the rescan loop. Requests made while a
+// rescan runs are merged into the next one.
+func (d *Daemon) requestRescan(dir string) {
+	d.rescanMu.Lock()
+	d.rescanDirs[dir] = struct{}{}
+	d.rescanMu.Unlock()
+
+	select {
+	case d.rescanKick <- struct{}{}:
+	default:
+	}
+}
+
+// rescanLoop compares the directories the watcher lost events for with the
+// metadata store and queues whatever differs, as if the watcher had
+// reported it.
+func (d *Daemon) rescanLoop(ctx context.Context) {
+	logger := logging.Logger
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.rescanKick:
+		}
+
+		d.rescanMu.Lock()
+		dirs := make([]string, 0, len(d.rescanDirs))
+		for dir := range d.rescanDirs {
+			dirs = append(dirs, dir)
+		}
+		d.rescanDirs = make(map[string]struct{})
+		d.rescanMu.Unlock()
+
+		// Parents sort first, so nested requests are covered by their parent.
+		sort.Strings(dirs)
+		var scanned []string
+		for _, dir := range dirs {
+			if under(dir, scanned) {
+				continue
+			}
+			scanned = append(scanned, dir)
+
+			metrics.Rescans.Inc()
+			changed, err := d.scan(dir)
+			if err != nil {
+				logger.Error("Rescan failed", zap.String("path", dir), zap.Error(err))
+				d.recordError(err)
+				continue
+			}
+			if len(changed) == 0 {
+				logger.Debug("Rescan found no changes", zap.String("path", dir))
+				continue
+			}
+			logger.Info("Rescan found changes", zap.String("path", dir), zap.Int("changed", len(changed)))
+			d.enqueue(changed...)
+		}
+	}
+}
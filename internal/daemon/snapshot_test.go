@@ -0,0 +1,105 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"git-fs/internal/config"
+	filemetadata "git-fs/internal/filemetadata"
+)
+
+func TestScan(t *testing.T) {
+	watch := t.TempDir()
+	write := func(rel, content string) {
+		path := filepath.Join(watch, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("unchanged.txt", "same")
+	write("dir/resized.txt", "longer than before")
+	write("touched.txt", "same")
+	write("new.txt", "new")
+	write("recorded.txt", "same")
+	write("rewritten.txt", "same")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, rel := range []string{"recorded.txt", "rewritten.txt"} {
+		if err := os.Chtimes(filepath.Join(watch, rel), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encrypted := time.Now().Add(time.Minute)
+	store := filemetadata.NewMetadataStore()
+	store.Metadata["a"] = filemetadata.FileMetadata{OriginalPath: "unchanged.txt", FileSize: 4, LastModified: encrypted}
+	store.Metadata["b"] = filemetadata.FileMetadata{OriginalPath: filepath.Join("dir", "resized.txt"), FileSize: 5, LastModified: encrypted}
+	store.Metadata["c"] = filemetadata.FileMetadata{OriginalPath: "touched.txt", FileSize: 4, LastModified: time.Now().Add(-time.Hour)}
+	store.Metadata["d"] = filemetadata.FileMetadata{OriginalPath: "deleted.txt", FileSize: 1, LastModified: encrypted}
+	// With the modification time recorded, only a different one counts.
+	store.Metadata["e"] = filemetadata.FileMetadata{OriginalPath: "recorded.txt", FileSize: 4, ModTime: old}
+	store.Metadata["f"] = filemetadata.FileMetadata{OriginalPath: "rewritten.txt", FileSize: 4, LastModified: encrypted, ModTime: old.Add(-time.Minute)}
+
+	d := &Daemon{cfg: &config.Config{WatchPath: watch}, metadataStore: store}
+	changed, err := d.scan(watch)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	var got []string
+	for _, path := range changed {
+		rel, _ := filepath.Rel(watch, path)
+		got = append(got, rel)
+	}
+	slices.Sort(got)
+	want := []string{"deleted.txt", filepath.Join("dir", "resized.txt"), "new.txt", "rewritten.txt", "touched.txt"}
+	if !slices.Equal(got, want) {
+		t.Errorf("scan returned %v, want %v", got, want)
+	}
+}
+
+func TestScanSubtree(t *testing.T) {
+	watch := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(watch, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"outside.txt", filepath.Join("sub", "inside.txt")} {
+		if err := os.WriteFile(filepath.Join(watch, rel), []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := filemetadata.NewMetadataStore()
+	store.Metadata["a"] = filemetadata.FileMetadata{OriginalPath: "gone.txt"}
+	store.Metadata["b"] = filemetadata.FileMetadata{OriginalPath: filepath.Join("sub", "gone.txt")}
+	store.Metadata["c"] = filemetadata.FileMetadata{OriginalPath: filepath.Join("removed", "gone.txt")}
+	d := &Daemon{cfg: &config.Config{WatchPath: watch}, metadataStore: store}
+
+	t.Run("Only the subtree is compared", func(t *testing.T) {
+		changed, err := d.scan(filepath.Join(watch, "sub"))
+		if err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		slices.Sort(changed)
+		want := []string{filepath.Join(watch, "sub", "gone.txt"), filepath.Join(watch, "sub", "inside.txt")}
+		if !slices.Equal(changed, want) {
+			t.Errorf("scan returned %v, want %v", changed, want)
+		}
+	})
+
+	t.Run("Removed directory reports its files", func(t *testing.T) {
+		changed, err := d.scan(filepath.Join(watch, "removed"))
+		if err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		want := []string{filepath.Join(watch, "removed", "gone.txt")}
+		if !slices.Equal(changed, want) {
+			t.Errorf("scan returned %v, want %v", changed, want)
+		}
+	})
+}
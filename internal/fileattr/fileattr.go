@@ -0,0 +1,108 @@
+// Package fileattr reads and restores the attributes of a file other than
+// its content: permissions, modification time, ownership and extended
+// attributes.
+package fileattr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+)
+
+// ErrOwner is returned by Apply when the owner can't be set, which takes
+// root unless it is the current user.
+var ErrOwner = errors.New("could not restore owner")
+
+// xattrPrefix is the namespace of the extended attributes that are kept.
+// Others belong to the system, such as security labels, and generally
+// can't be set by users or don't carry over to another machine.
+const xattrPrefix = "user."
+
+// modeMask selects the bits of a file mode that are preserved.
+const modeMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// Owner is the numeric owner and group of a file.
+type Owner struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// Attrs are the attributes of a file preserved along with its content.
+type Attrs struct {
+	Mode    os.FileMode // Permissions, setuid, setgid and sticky bits
+	ModTime time.Time
+	Owner   *Owner            // Nil if not recorded
+	Xattrs  map[string][]byte // Extended attributes in the user namespace
+}
+
+// FromInfo returns the attributes in info, which are all but the extended
+// attributes. The owner is only included if withOwner is set.
+func FromInfo(info os.FileInfo, withOwner bool) Attrs {
+	a := Attrs{Mode: info.Mode() & modeMask, ModTime: info.ModTime()}
+	if withOwner {
+		a.Owner = ownerOf(info)
+	}
+	return a
+}
+
+// Read returns the attributes of the file at path, described by info.
+func Read(path string, info os.FileInfo, withOwner bool) (Attrs, error) {
+	a := FromInfo(info, withOwner)
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return Attrs{}, fmt.Errorf("reading extended attributes: %w", err)
+	}
+	a.Xattrs = xattrs
+	return a, nil
+}
+
+// Matches reports whether a and b have the same mode, owner and extended
+// attributes. Modification times are left to the caller.
+func (a Attrs) Matches(b Attrs) bool {
+	if a.Mode != b.Mode || (a.Owner == nil) != (b.Owner == nil) || a.Owner != nil && *a.Owner != *b.Owner {
+		return false
+	}
+	return maps.EqualFunc(a.Xattrs, b.Xattrs, bytes.Equal)
+}
+
+// Options select what Apply restores.
+type Options struct {
+	Owner bool
+}
+
+// Apply sets the attributes in a on the file at path. Zero values are left
+// alone. The owner is set first, since changing it clears the setuid and
+// setgid bits, and the modification time last.
+func Apply(path string, a Attrs, opts Options) error {
+	if opts.Owner && a.Owner != nil {
+		if err := os.Lchown(path, a.Owner.UID, a.Owner.GID); err != nil {
+			return fmt.Errorf("%w: %v", ErrOwner, err)
+		}
+	}
+	// Setting extended attributes takes write permission, which a file
+	// restored before may not have.
+	if len(a.Xattrs) > 0 && a.Mode != 0 {
+		if err := os.Chmod(path, a.Mode|0200); err != nil {
+			return err
+		}
+	}
+	for name, value := range a.Xattrs {
+		if err := setXattr(path, name, value); err != nil {
+			return fmt.Errorf("setting extended attribute %s: %w", name, err)
+		}
+	}
+	if a.Mode != 0 {
+		if err := os.Chmod(path, a.Mode); err != nil {
+			return err
+		}
+	}
+	if !a.ModTime.IsZero() {
+		if err := os.Chtimes(path, time.Time{}, a.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package fileattr
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestReadApply(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, path := range []string{src, dst} {
+		if err := os.WriteFile(path, []byte("content"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chmod(src, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(src, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	withXattrs := runtime.GOOS == "linux"
+	if withXattrs {
+		if err := setXattr(src, "user.test", []byte("value")); err != nil {
+			t.Logf("extended attributes not supported: %v", err)
+			withXattrs = false
+		}
+	}
+
+	read := func(path string) Attrs {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := Read(path, info, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return attrs
+	}
+
+	attrs := read(src)
+	if attrs.Mode != 0750 || !attrs.ModTime.Equal(modTime) {
+		t.Errorf("read mode %v and time %v", attrs.Mode, attrs.ModTime)
+	}
+	if withXattrs && string(attrs.Xattrs["user.test"]) != "value" {
+		t.Errorf("read extended attributes %q", attrs.Xattrs)
+	}
+	if runtime.GOOS != "windows" && (attrs.Owner == nil || attrs.Owner.UID != os.Getuid()) {
+		t.Errorf("read owner %+v", attrs.Owner)
+	}
+
+	if err := Apply(dst, attrs, Options{Owner: true}); err != nil {
+		t.Fatal(err)
+	}
+	got := read(dst)
+	if !got.Matches(attrs) || !got.ModTime.Equal(modTime) {
+		t.Errorf("applied %+v, got %+v", attrs, got)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	base := Attrs{Mode: 0644, Owner: &Owner{UID: 1, GID: 2}, Xattrs: map[string][]byte{"user.a": []byte("1")}}
+	for name, tc := range map[string]struct {
+		change func(*Attrs)
+		want   bool
+	}{
+		"same":      {func(a *Attrs) { a.Owner = &Owner{UID: 1, GID: 2} }, true},
+		"mod time":  {func(a *Attrs) { a.ModTime = time.Now() }, true},
+		"mode":      {func(a *Attrs) { a.Mode = 0755 }, false},
+		"owner":     {func(a *Attrs) { a.Owner = &Owner{UID: 1, GID: 3} }, false},
+		"no owner":  {func(a *Attrs) { a.Owner = nil }, false},
+		"xattr":     {func(a *Attrs) { a.Xattrs = map[string][]byte{"user.a": []byte("2")} }, false},
+		"no xattrs": {func(a *Attrs) { a.Xattrs = nil }, false},
+	} {
+		other := base
+		tc.change(&other)
+		if got := base.Matches(other); got != tc.want {
+			t.Errorf("%s: Matches = %v, want %v", name, got, tc.want)
+		}
+	}
+}
+
+func TestApplyReadOnly(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("extended attributes are only restored on Linux")
+	}
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := setXattr(path, "user.probe", nil); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	// Applied twice, as when resuming a restore; the second time the file
+	// is read-only already.
+	attrs := Attrs{Mode: 0400, Xattrs: map[string][]byte{"user.test": []byte("value")}}
+	for range 2 {
+		if err := Apply(path, attrs, Options{}); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0400 {
+		t.Errorf("mode after Apply: %v, want %v", info.Mode(), os.FileMode(0400))
+	}
+}
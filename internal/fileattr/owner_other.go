@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fileattr
+
+import "os"
+
+// ownerOf can't tell the owner of a file on this platform.
+func ownerOf(info os.FileInfo) *Owner {
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package fileattr
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownerOf returns the owner of the file info describes.
+func ownerOf(info os.FileInfo) *Owner {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return &Owner{UID: int(st.Uid), GID: int(st.Gid)}
+	}
+	return nil
+}
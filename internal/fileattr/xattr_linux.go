@@ -0,0 +1,49 @@
+//go:build linux
+
+package fileattr
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// readXattrs returns the extended attributes of the file at path in the
+// user namespace. A filesystem without them has none.
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		if errors.Is(err, syscall.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	list := make([]byte, size)
+	if size, err = syscall.Listxattr(path, list); err != nil {
+		return nil, err
+	}
+
+	var xattrs map[string][]byte
+	for _, name := range strings.Split(string(list[:size]), "\x00") {
+		if !strings.HasPrefix(name, xattrPrefix) {
+			continue
+		}
+		n, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(path, name, value); err != nil {
+			return nil, err
+		}
+		if xattrs == nil {
+			xattrs = make(map[string][]byte)
+		}
+		xattrs[name] = value[:n]
+	}
+	return xattrs, nil
+}
+
+func setXattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fileattr
+
+import "errors"
+
+// readXattrs doesn't read extended attributes on this platform.
+func readXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+func setXattr(path, name string, value []byte) error {
+	return errors.ErrUnsupported
+}
@@ -1,42 +1,250 @@
 package daemon
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"git-fs/internal/crypto"
+	"git-fs/internal/fileattr"
+	fileutils "git-fs/internal/fileutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// CounterFileName is where the highest metadata counter seen is remembered,
+// relative to the repo. It is local state and never committed.
+const CounterFileName = ".git-fs.counter"
+
+// ErrRollback is returned when the metadata store is older than one seen before.
+var ErrRollback = errors.New("metadata store was rolled back")
+
 // FileMetadata stores encryption and integrity information
 type FileMetadata struct {
 	EncryptedName   string    `json:"encrypted_name"`
 	OriginalPath    string    `json:"original_path"`  // Stored encrypted
-	OriginalHash    string    `json:"original_hash"`  // SHA-256 of original file
+	OriginalHash    string    `json:"original_hash"`  // Keyed MAC of original file
 	EncryptedHash   string    `json:"encrypted_hash"` // SHA-256 of encrypted file
 	LastModified    time.Time `json:"last_modified"`
+	ModTime         time.Time `json:"mod_time"` // Modification time of the original when it was read
 	FileSize        int64     `json:"file_size"`
-	EncryptionNonce []byte    `json:"encryption_nonce"` // For filename encryption
-	FileNonce       []byte    `json:"file_nonce"`       // For file content encryption
+	KeyVersion      int       `json:"key_version,omitempty"` // Which key the content was encrypted with, see crypto.Keys
+	BlobFormat      int       `json:"blob_format,omitempty"` // crypto.BlobFormatLegacy, or one with a header
+	EncryptionNonce []byte    `json:"encryption_nonce"`      // For filename encryption
+	FileNonce       []byte    `json:"file_nonce"`            // For file content encryption in BlobFormatLegacy
+	Pack            string    `json:"pack,omitempty"`        // Pack file holding the blob; empty if it is stored on its own
+
+	// LegacyHash is the plain SHA-256 a version 0 store recorded as
+	// OriginalHash. It is only kept in memory, until UpgradeHash replaces it.
+	LegacyHash string `json:"-"`
+
+	// Attributes of the original besides ModTime, restored by decrypt.
+	Mode   os.FileMode       `json:"mode,omitempty"`   // Zero if not recorded
+	Owner  *fileattr.Owner   `json:"owner,omitempty"`  // Only with preserve_ownership
+	Xattrs map[string][]byte `json:"xattrs,omitempty"` // In the user namespace
+}
+
+// Attrs returns the attributes of the original file m describes.
+func (m FileMetadata) Attrs() fileattr.Attrs {
+	return fileattr.Attrs{Mode: m.Mode, ModTime: m.ModTime, Owner: m.Owner, Xattrs: m.Xattrs}
+}
+
+// SetAttrs records a as the attributes of the original file.
+func (m *FileMetadata) SetAttrs(a fileattr.Attrs) {
+	m.Mode, m.ModTime, m.Owner, m.Xattrs = a.Mode, a.ModTime, a.Owner, a.Xattrs
+}
+
+// UpgradeHash replaces the plain hash of a version 0 store with mac, the
+// keyed MAC of content, if content is what that hash was recorded for. It
+// reports whether it did.
+func (m *FileMetadata) UpgradeHash(content []byte, mac string) bool {
+	if m.LegacyHash == "" || m.FileSize != int64(len(content)) {
+		return false
+	}
+	hash := sha256.Sum256(content)
+	if base64.StdEncoding.EncodeToString(hash[:]) != m.LegacyHash {
+		return false
+	}
+	m.OriginalHash, m.LegacyHash = mac, ""
+	return true
+}
+
+// Open decrypts blob, the encrypted content of the file m describes.
+func (m FileMetadata) Open(keys *crypto.Keys, blob []byte) ([]byte, error) {
+	if m.BlobFormat == crypto.BlobFormatLegacy {
+		return crypto.OpenLegacyBlob(keys.ContentKey(m.KeyVersion), m.FileNonce, blob)
+	}
+	return crypto.OpenBlob(keys, m.EncryptedName, blob)
 }
 
+// Version is the current format of the metadata store.
+//
+//	0: OriginalHash is a plain SHA-256
+//	1: OriginalHash is a keyed MAC
+const Version = 1
+
 type MetadataStore struct {
 	Mu       sync.RWMutex
+	Version  int                     `json:"version"`
 	Metadata map[string]FileMetadata `json:"metadata"` // Maps encrypted filename to metadata
+
+	// Counter is sealed with the store and increases with every commit, so
+	// that an older store can't be put back undetected. See RecordCounter.
+	Counter uint64 `json:"-"`
+
+	// byPath maps OriginalPath to the encrypted filename. It is kept current
+	// by Put and Remove and rebuilt by Clone and LoadMetadataStore.
+	byPath map[string]string
 }
 
 type ChangeSet struct {
 	Mu    sync.Mutex
 	Files map[string]struct{}
+	Since time.Time // When the oldest change in Files arrived
 }
 
 func NewMetadataStore() *MetadataStore {
 	return &MetadataStore{
+		Version:  Version,
 		Metadata: make(map[string]FileMetadata),
+		byPath:   make(map[string]string),
+	}
+}
+
+func (ms *MetadataStore) reindex() {
+	ms.byPath = make(map[string]string, len(ms.Metadata))
+	for encName, metadata := range ms.Metadata {
+		ms.byPath[metadata.OriginalPath] = encName
+	}
+}
+
+// upgrade converts an older store to the current version. It is saved in
+// the new format with the next commit.
+func (ms *MetadataStore) upgrade() {
+	if ms.Version < 1 {
+		// Unkeyed hashes would let anyone holding the metadata confirm the
+		// content of a file. Keep them out of the saved store; until a file
+		// is read again and UpgradeHash swaps in the keyed one, they only
+		// live in memory.
+		for encName, metadata := range ms.Metadata {
+			metadata.LegacyHash, metadata.OriginalHash = metadata.OriginalHash, ""
+			ms.Metadata[encName] = metadata
+		}
+	}
+	ms.Version = Version
+}
+
+// Put stores metadata under encName, replacing any previous entry for it.
+func (ms *MetadataStore) Put(encName string, metadata FileMetadata) {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+
+	if old, ok := ms.Metadata[encName]; ok && ms.byPath[old.OriginalPath] == encName {
+		delete(ms.byPath, old.OriginalPath)
+	}
+	ms.Metadata[encName] = metadata
+	ms.byPath[metadata.OriginalPath] = encName
+}
+
+// Remove deletes the entry for encName.
+func (ms *MetadataStore) Remove(encName string) {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+
+	if old, ok := ms.Metadata[encName]; ok && ms.byPath[old.OriginalPath] == encName {
+		delete(ms.byPath, old.OriginalPath)
+	}
+	delete(ms.Metadata, encName)
+}
+
+// Replace makes the store hold the entries of other, which must not be used afterwards.
+func (ms *MetadataStore) Replace(other *MetadataStore) {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+
+	ms.Version = other.Version
+	ms.Counter = other.Counter
+	ms.Metadata = other.Metadata
+	ms.byPath = other.byPath
+}
+
+// Clone returns a copy of the store that can be modified independently.
+func (ms *MetadataStore) Clone() *MetadataStore {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+
+	clone := NewMetadataStore()
+	clone.Version = ms.Version
+	clone.Counter = ms.Counter
+	for name, metadata := range ms.Metadata {
+		clone.Metadata[name] = metadata
+	}
+	clone.reindex()
+	return clone
+}
+
+// LegacyPaths returns the original paths of the entries that still have a
+// plain hash from a version 0 store.
+func (ms *MetadataStore) LegacyPaths() []string {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+
+	var paths []string
+	for _, metadata := range ms.Metadata {
+		if metadata.LegacyHash != "" {
+			paths = append(paths, metadata.OriginalPath)
+		}
+	}
+	return paths
+}
+
+// FindByPath returns the encrypted name and metadata stored for originalPath.
+func (ms *MetadataStore) FindByPath(originalPath string) (string, FileMetadata, bool) {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+
+	encName, ok := ms.byPath[originalPath]
+	if !ok {
+		return "", FileMetadata{}, false
+	}
+	return encName, ms.Metadata[encName], true
+}
+
+// FindUnder returns the encrypted names of all files stored below the directory dir.
+func (ms *MetadataStore) FindUnder(dir string) []string {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+
+	prefix := dir + string(filepath.Separator)
+	var names []string
+	for encName, metadata := range ms.Metadata {
+		if strings.HasPrefix(metadata.OriginalPath, prefix) {
+			names = append(names, encName)
+		}
+	}
+	return names
+}
+
+// Totals returns the number of files in the store and their combined size.
+func (ms *MetadataStore) Totals() (int, int64) {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+
+	var size int64
+	for _, metadata := range ms.Metadata {
+		size += metadata.FileSize
 	}
+	return len(ms.Metadata), size
 }
 
-func (ms *MetadataStore) SaveToFile(path string, key []byte) error {
+// SaveToFile encrypts the store with suite and the metadata subkey and
+// writes it to path.
+func (ms *MetadataStore) SaveToFile(path string, keys *crypto.Keys, suite *crypto.Suite) error {
 	ms.Mu.RLock()
 	defer ms.Mu.RUnlock()
 
@@ -46,15 +254,18 @@ func (ms *MetadataStore) SaveToFile(path string, key []byte) error {
 	}
 
 	// Encrypt the metadata before saving
-	encryptedData, err := crypto.Encrypt(key, data)
+	encryptedData, err := crypto.SealMetadata(keys.Metadata, suite, ms.Counter, data)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(path, encryptedData, 0600)
+	return fileutils.WriteFileAtomic(path, encryptedData, 0600)
 }
 
-func LoadMetadataStore(path string, key []byte) (*MetadataStore, error) {
+// LoadMetadataStore reads the store saved at path, or returns an empty one if
+// there is none. Stores written with the repository key itself, before
+// subkeys were introduced, are read too.
+func LoadMetadataStore(path string, keys *crypto.Keys) (*MetadataStore, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -64,15 +275,48 @@ func LoadMetadataStore(path string, key []byte) (*MetadataStore, error) {
 	}
 
 	// Decrypt the metadata
-	decryptedData, err := crypto.Decrypt(key, data)
+	decryptedData, counter, _, err := crypto.OpenMetadata(keys.Metadata, data)
 	if err != nil {
-		return nil, err
+		var legacyErr error
+		if decryptedData, counter, _, legacyErr = crypto.OpenMetadata(keys.Legacy, data); legacyErr != nil {
+			return nil, err
+		}
 	}
 
-	ms := NewMetadataStore()
+	// Stores from before the version field decode as version 0.
+	ms := &MetadataStore{}
 	if err := json.Unmarshal(decryptedData, ms); err != nil {
 		return nil, err
 	}
+	if ms.Metadata == nil {
+		ms.Metadata = make(map[string]FileMetadata)
+	}
+	ms.Counter = counter
+	ms.upgrade()
+	ms.reindex()
 
 	return ms, nil
 }
+
+// RecordCounter remembers counter in the file at path as that of the newest
+// metadata store seen. It fails with ErrRollback if a newer one was seen
+// before.
+func RecordCounter(path string, counter uint64) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		seen, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid metadata counter in %s: %w", path, err)
+		}
+		if counter < seen {
+			return fmt.Errorf("%w: counter %d is older than %d, seen before", ErrRollback, counter, seen)
+		}
+		if counter == seen {
+			return nil
+		}
+	}
+	return fileutils.WriteFileAtomic(path, []byte(strconv.FormatUint(counter, 10)+"\n"), 0600)
+}
@@ -1,15 +1,28 @@
 package daemon
 
 import (
+	"encoding/json"
+	"errors"
+	"git-fs/internal/crypto"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
+func testKeys(t *testing.T) *crypto.Keys {
+	t.Helper()
+	keys, err := crypto.DeriveKeys([]byte("12345678901234567890123456789012"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
 func TestMetadataStore(t *testing.T) {
 	// Setup
 	tmpFile := "test_metadata.db"
-	key := []byte("12345678901234567890123456789012")
+	keys := testKeys(t)
 
 	// Clean up after test
 	defer os.Remove(tmpFile)
@@ -39,13 +52,13 @@ func TestMetadataStore(t *testing.T) {
 		ms.Metadata["encrypted.txt"] = testMeta
 
 		// Save to file
-		err := ms.SaveToFile(tmpFile, key)
+		err := ms.SaveToFile(tmpFile, keys, crypto.AES256GCM)
 		if err != nil {
 			t.Fatalf("Failed to save metadata: %v", err)
 		}
 
 		// Load from file
-		loaded, err := LoadMetadataStore(tmpFile, key)
+		loaded, err := LoadMetadataStore(tmpFile, keys)
 		if err != nil {
 			t.Fatalf("Failed to load metadata: %v", err)
 		}
@@ -65,7 +78,7 @@ func TestMetadataStore(t *testing.T) {
 	})
 
 	t.Run("Load non-existent file", func(t *testing.T) {
-		ms, err := LoadMetadataStore("nonexistent.db", key)
+		ms, err := LoadMetadataStore("nonexistent.db", keys)
 		if err != nil {
 			t.Fatalf("Expected no error for non-existent file, got: %v", err)
 		}
@@ -74,3 +87,147 @@ func TestMetadataStore(t *testing.T) {
 		}
 	})
 }
+
+func TestMetadataStorePathIndex(t *testing.T) {
+	ms := NewMetadataStore()
+	ms.Put("a", FileMetadata{OriginalPath: "one.txt"})
+	ms.Put("b", FileMetadata{OriginalPath: "two.txt"})
+
+	t.Run("Find by path", func(t *testing.T) {
+		if encName, _, ok := ms.FindByPath("one.txt"); !ok || encName != "a" {
+			t.Errorf("FindByPath(one.txt) = %q, %v", encName, ok)
+		}
+		if _, _, ok := ms.FindByPath("missing.txt"); ok {
+			t.Error("FindByPath found a missing path")
+		}
+	})
+
+	t.Run("Put moves the path", func(t *testing.T) {
+		ms.Put("a", FileMetadata{OriginalPath: "renamed.txt"})
+		if _, _, ok := ms.FindByPath("one.txt"); ok {
+			t.Error("old path is still indexed")
+		}
+		if encName, _, ok := ms.FindByPath("renamed.txt"); !ok || encName != "a" {
+			t.Errorf("FindByPath(renamed.txt) = %q, %v", encName, ok)
+		}
+	})
+
+	t.Run("Clone has its own index", func(t *testing.T) {
+		clone := ms.Clone()
+		clone.Remove("b")
+		if _, _, ok := clone.FindByPath("two.txt"); ok {
+			t.Error("removed path is still indexed in the clone")
+		}
+		if _, _, ok := ms.FindByPath("two.txt"); !ok {
+			t.Error("removing from the clone changed the original")
+		}
+
+		ms.Replace(clone)
+		if _, _, ok := ms.FindByPath("two.txt"); ok {
+			t.Error("Replace kept the old index")
+		}
+	})
+
+	t.Run("Loaded store is indexed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metadata.db")
+		keys := testKeys(t)
+		if err := ms.SaveToFile(path, keys, crypto.AES256GCM); err != nil {
+			t.Fatalf("Failed to save metadata: %v", err)
+		}
+		loaded, err := LoadMetadataStore(path, keys)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+		if encName, _, ok := loaded.FindByPath("renamed.txt"); !ok || encName != "a" {
+			t.Errorf("FindByPath(renamed.txt) = %q, %v", encName, ok)
+		}
+	})
+}
+
+func TestMetadataStoreUpgrade(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	keys := testKeys(t)
+
+	// A store written with the repository key before the version field,
+	// with unkeyed hashes.
+	legacy, err := json.Marshal(map[string]any{
+		"metadata": map[string]FileMetadata{
+			"a": {EncryptedName: "a", OriginalPath: "one.txt", OriginalHash: "sha256", FileSize: 3},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := crypto.Encrypt(keys.Legacy, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := LoadMetadataStore(path, keys)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	if ms.Version != Version {
+		t.Errorf("Expected version %d, got %d", Version, ms.Version)
+	}
+	encName, metadata, ok := ms.FindByPath("one.txt")
+	if !ok || encName != "a" {
+		t.Fatalf("Entry lost in upgrade: %q, %v", encName, ok)
+	}
+	if metadata.OriginalHash != "" {
+		t.Errorf("Unkeyed hash kept: %q", metadata.OriginalHash)
+	}
+	if metadata.LegacyHash != "sha256" {
+		t.Errorf("Unkeyed hash not kept in memory: %q", metadata.LegacyHash)
+	}
+	if paths := ms.LegacyPaths(); len(paths) != 1 || paths[0] != "one.txt" {
+		t.Errorf("LegacyPaths() = %v", paths)
+	}
+	if metadata.FileSize != 3 {
+		t.Errorf("Expected file size 3, got %d", metadata.FileSize)
+	}
+
+	// Current stores keep their hashes.
+	ms.Put("a", FileMetadata{EncryptedName: "a", OriginalPath: "one.txt", OriginalHash: "mac"})
+	ms.Counter = 7
+	if err := ms.SaveToFile(path, keys, crypto.AES256GCM); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crypto.Decrypt(keys.Legacy, saved); err == nil {
+		t.Error("Store saved with the repository key instead of the metadata subkey")
+	}
+	loaded, err := LoadMetadataStore(path, keys)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	if _, metadata, _ := loaded.FindByPath("one.txt"); metadata.OriginalHash != "mac" {
+		t.Errorf("Hash not kept: %q", metadata.OriginalHash)
+	}
+	if loaded.Counter != ms.Counter {
+		t.Errorf("Expected counter %d, got %d", ms.Counter, loaded.Counter)
+	}
+}
+
+func TestRecordCounter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CounterFileName)
+
+	if err := RecordCounter(path, 3); err != nil {
+		t.Fatalf("RecordCounter failed on first use: %v", err)
+	}
+	if err := RecordCounter(path, 3); err != nil {
+		t.Errorf("RecordCounter failed for the same counter: %v", err)
+	}
+	if err := RecordCounter(path, 5); err != nil {
+		t.Errorf("RecordCounter failed for a newer counter: %v", err)
+	}
+	if err := RecordCounter(path, 4); !errors.Is(err, ErrRollback) {
+		t.Errorf("Expected ErrRollback for an older counter, got %v", err)
+	}
+}
@@ -1,24 +1,62 @@
 package fileutils
 
 import (
-	"io/ioutil"
 	"os"
 	"path/filepath"
 )
 
-// WriteFileAtomic writes data to a file atomically.
-func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
-	tmpfile, err := ioutil.TempFile(filepath.Dir(filename), "tmp")
+// beforeRename is called once the temporary file is durable. Tests use it to
+// simulate a crash between writing the data and publishing it.
+var beforeRename = func(tmpName string) error { return nil }
+
+// WriteFileAtomic writes data to a file atomically and durably. The data goes
+// to a temporary file in the same directory, which is fsynced and renamed over
+// filename before the directory itself is fsynced, so after a crash filename
+// holds either the old or the new content, never a partial write.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(filename)
+	tmpfile, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer os.Remove(tmpfile.Name())
+	tmpName := tmpfile.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(tmpName)
+		}
+	}()
 
-	if _, err := tmpfile.Write(data); err != nil {
+	if _, err = tmpfile.Write(data); err != nil {
 		tmpfile.Close()
 		return err
 	}
-	tmpfile.Close()
+	if err = tmpfile.Chmod(perm); err != nil {
+		tmpfile.Close()
+		return err
+	}
+	if err = tmpfile.Sync(); err != nil {
+		tmpfile.Close()
+		return err
+	}
+	if err = tmpfile.Close(); err != nil {
+		return err
+	}
 
-	return os.Rename(tmpfile.Name(), filename)
+	if err = beforeRename(tmpName); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+	return SyncDir(dir)
+}
+
+// SyncDir fsyncs a directory so that renames and removals inside it survive a crash.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
@@ -0,0 +1,89 @@
+package fileutils
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// crashEnv makes the test binary act as a writer that dies mid-write.
+const crashEnv = "GITFS_TEST_CRASH_WRITE"
+
+func TestMain(m *testing.M) {
+	if target := os.Getenv(crashEnv); target != "" {
+		// Exit without cleanup right before the rename, as a power cut or
+		// SIGKILL would.
+		beforeRename = func(string) error {
+			os.Exit(3)
+			return nil
+		}
+		WriteFileAtomic(target, []byte("new content"), 0600)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	if string(data) != want {
+		t.Errorf("Expected %q, got %q", want, data)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	t.Run("Writes new file", func(t *testing.T) {
+		target := filepath.Join(t.TempDir(), "file")
+		if err := WriteFileAtomic(target, []byte("content"), 0640); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		assertContent(t, target, "content")
+
+		info, err := os.Stat(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("Expected mode 0640, got %v", info.Mode().Perm())
+		}
+	})
+
+	t.Run("Failed write keeps old content", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "file")
+		os.WriteFile(target, []byte("old content"), 0600)
+
+		beforeRename = func(string) error { return errors.New("disk full") }
+		defer func() { beforeRename = func(string) error { return nil } }()
+
+		if err := WriteFileAtomic(target, []byte("new content"), 0600); err == nil {
+			t.Fatal("Expected write to fail")
+		}
+		assertContent(t, target, "old content")
+
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 1 {
+			t.Errorf("Expected temporary file to be removed, found %d entries", len(entries))
+		}
+	})
+
+	t.Run("Crash before rename keeps old content", func(t *testing.T) {
+		target := filepath.Join(t.TempDir(), "file")
+		os.WriteFile(target, []byte("old content"), 0600)
+
+		cmd := exec.Command(os.Args[0], "-test.run=^$")
+		cmd.Env = append(os.Environ(), crashEnv+"="+target)
+		err := cmd.Run()
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+			t.Fatalf("Expected writer to crash with exit code 3, got %v", err)
+		}
+		assertContent(t, target, "old content")
+	})
+}
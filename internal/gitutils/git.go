@@ -1,7 +1,11 @@
 package gitutils
 
 import (
+	"context"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -21,13 +25,40 @@ func AddAndCommit(repoPath, message string) error {
 	return nil
 }
 
-// Push pushes the current branch to the given remote and branch.
-func Push(repoPath, remote, branch string) error {
-	cmd := exec.Command("git", "push", remote, branch)
+// Push pushes the current branch to the given remote and branch. git is
+// killed if ctx is done first.
+func Push(ctx context.Context, repoPath, remote, branch string) error {
+	cmd := exec.CommandContext(ctx, "git", "push", remote, branch)
 	cmd.Dir = repoPath
 	return cmd.Run()
 }
 
+// UnpushedCount returns how many commits on HEAD are not on remote/branch.
+// Before the first push every commit counts as unpushed.
+func UnpushedCount(repoPath, remote, branch string) (int, error) {
+	count, err := revCount(repoPath, remote+"/"+branch+"..HEAD")
+	if err == nil {
+		return count, nil
+	}
+	// The remote-tracking branch does not exist before the first push.
+	count, err = revCount(repoPath, "HEAD")
+	if err != nil {
+		// HEAD has no commits yet.
+		return 0, nil
+	}
+	return count, nil
+}
+
+func revCount(repoPath, revRange string) (int, error) {
+	cmd := exec.Command("git", "rev-list", "--count", revRange)
+	cmd.Dir = repoPath
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
+
 // GetLastCommitHash returns the latest commit hash in the given repository.
 func GetLastCommitHash(repoPath string) (string, error) {
 	cmd := exec.Command("git", "rev-parse", "HEAD")
@@ -38,3 +69,59 @@ func GetLastCommitHash(repoPath string) (string, error) {
 	}
 	return strings.TrimSpace(string(out)), nil
 }
+
+// HasChanges reports whether the working tree has anything to commit.
+func HasChanges(repoPath string) (bool, error) {
+	cmd := exec.Command("git", "status", "--porcelain")
+	cmd.Dir = repoPath
+	out, err := cmd.Output()
+	if err != nil {
+		return false, err
+	}
+	return len(strings.TrimSpace(string(out))) > 0, nil
+}
+
+// EnsureExcluded adds pattern to .git/info/exclude so that local state files
+// are never picked up by `git add .`. It is a no-op if repoPath is not a git repository.
+func EnsureExcluded(repoPath, pattern string) error {
+	if !isRepo(repoPath) {
+		return nil
+	}
+
+	excludePath := filepath.Join(repoPath, ".git", "info", "exclude")
+	existing, err := os.ReadFile(excludePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(existing), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		existing = append(existing, '\n')
+	}
+	existing = append(existing, pattern+"\n"...)
+
+	if err := os.MkdirAll(filepath.Dir(excludePath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(excludePath, existing, 0644)
+}
+
+// Untrack removes path from the index without touching the working tree, so
+// that a file that was committed by mistake can be ignored from now on.
+func Untrack(repoPath, path string) error {
+	if !isRepo(repoPath) {
+		return nil
+	}
+	cmd := exec.Command("git", "rm", "--cached", "--ignore-unmatch", "--quiet", "--", path)
+	cmd.Dir = repoPath
+	return cmd.Run()
+}
+
+func isRepo(repoPath string) bool {
+	info, err := os.Stat(filepath.Join(repoPath, ".git"))
+	return err == nil && info.IsDir()
+}
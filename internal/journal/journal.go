@@ -0,0 +1,223 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	fileutils "git-fs/internal/fileutil"
+)
+
+// State describes how far a batch got before the journal was last written.
+type State string
+
+const (
+	// StatePrepared means blobs are being staged; nothing in the repository
+	// has been touched yet, so the batch is rolled back on recovery.
+	StatePrepared State = "prepared"
+	// StateCommitted means all blobs and the new metadata are staged; the
+	// batch is rolled forward on recovery.
+	StateCommitted State = "committed"
+)
+
+const (
+	OpWrite  = "write"
+	OpDelete = "delete"
+)
+
+var ErrBatchPending = errors.New("a journaled batch is still pending")
+
+// Op is a single intended change to the object directory.
+type Op struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"` // Object name relative to the object directory
+}
+
+// Journal records the operations of one batch at a time so that the
+// repository either sees all of them or none of them.
+type Journal struct {
+	dir          string
+	objectDir    string
+	metadataPath string
+}
+
+// Batch is a set of staged operations tracked by the journal.
+type Batch struct {
+	j *Journal
+
+	ID       string    `json:"id"`
+	State    State     `json:"state"`
+	Started  time.Time `json:"started"`
+	Ops      []Op      `json:"ops"`
+	Metadata bool      `json:"metadata"`          // Whether a new metadata file is staged
+	Message  string    `json:"message,omitempty"` // Git commit message, set before Commit
+
+	// Sources are the watched files the batch was made from, so that a
+	// batch rolled back on recovery can be redone. They are plaintext
+	// paths; the journal is local state and never committed.
+	Sources []string `json:"sources,omitempty"`
+}
+
+// New returns a journal stored in dir that applies batches to objectDir and metadataPath.
+func New(dir, objectDir, metadataPath string) *Journal {
+	return &Journal{dir: dir, objectDir: objectDir, metadataPath: metadataPath}
+}
+
+func (j *Journal) recordPath() string {
+	return filepath.Join(j.dir, "journal.json")
+}
+
+func (j *Journal) stagingDir() string {
+	return filepath.Join(j.dir, "staged")
+}
+
+// Pending returns the batch left behind by a previous run, or nil if there is none.
+func (j *Journal) Pending() (*Batch, error) {
+	data, err := os.ReadFile(j.recordPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	b := &Batch{j: j}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("corrupt journal %q: %w", j.recordPath(), err)
+	}
+	return b, nil
+}
+
+// Begin starts a new batch made from the watched files in sources. It fails
+// if a previous batch has not been recovered yet.
+func (j *Journal) Begin(sources []string) (*Batch, error) {
+	pending, err := j.Pending()
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		return nil, ErrBatchPending
+	}
+
+	// Leftovers from a batch that crashed before its record was written.
+	if err := os.RemoveAll(j.stagingDir()); err != nil {
+		return nil, err
+	}
+	if err := fileutils.EnsureDir(j.stagingDir()); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	b := &Batch{
+		j:       j,
+		ID:      now.Format("20060102T150405.000000000"),
+		State:   StatePrepared,
+		Started: now,
+		Sources: sources,
+	}
+	if err := b.save(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Batch) save() error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutils.WriteFileAtomic(b.j.recordPath(), data, 0600)
+}
+
+// StagePath returns where the blob for name has to be written before Commit.
+func (b *Batch) StagePath(name string) string {
+	return filepath.Join(b.j.stagingDir(), name)
+}
+
+// MetadataPath returns where the new metadata file has to be written before Commit.
+func (b *Batch) MetadataPath() string {
+	return filepath.Join(b.j.dir, "metadata.enc")
+}
+
+// Write records that the staged blob name will be moved into the object directory.
+func (b *Batch) Write(name string) {
+	b.Ops = append(b.Ops, Op{Kind: OpWrite, Name: name})
+}
+
+// Delete records that the object name will be removed from the object directory.
+func (b *Batch) Delete(name string) {
+	b.Ops = append(b.Ops, Op{Kind: OpDelete, Name: name})
+}
+
+// Empty reports whether the batch has nothing to apply.
+func (b *Batch) Empty() bool {
+	return len(b.Ops) == 0
+}
+
+// Commit marks the batch as committed. From this point on the batch is
+// rolled forward on recovery instead of being discarded.
+func (b *Batch) Commit() error {
+	if _, err := os.Stat(b.MetadataPath()); err != nil {
+		return fmt.Errorf("metadata not staged: %w", err)
+	}
+	b.Metadata = true
+	b.State = StateCommitted
+	return b.save()
+}
+
+// Apply moves staged blobs and metadata into place and removes deleted
+// objects. It is idempotent, so a committed batch can be applied again
+// after a crash.
+func (b *Batch) Apply() error {
+	if b.State != StateCommitted {
+		return fmt.Errorf("batch %s is not committed", b.ID)
+	}
+	if err := fileutils.EnsureDir(b.j.objectDir); err != nil {
+		return err
+	}
+
+	for _, op := range b.Ops {
+		target := filepath.Join(b.j.objectDir, op.Name)
+		switch op.Kind {
+		case OpWrite:
+			if err := os.Rename(b.StagePath(op.Name), target); err != nil {
+				// Already moved by an earlier, interrupted Apply.
+				if os.IsNotExist(err) && fileutils.FileExists(target) {
+					continue
+				}
+				return err
+			}
+		case OpDelete:
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown journal operation %q", op.Kind)
+		}
+	}
+
+	if err := fileutils.SyncDir(b.j.objectDir); err != nil {
+		return err
+	}
+
+	if b.Metadata {
+		if err := os.Rename(b.MetadataPath(), b.j.metadataPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return fileutils.SyncDir(filepath.Dir(b.j.metadataPath))
+	}
+	return nil
+}
+
+// Finish removes the journal once the batch is fully applied.
+func (b *Batch) Finish() error {
+	return os.RemoveAll(b.j.dir)
+}
+
+// Rollback discards everything staged for the batch.
+func (b *Batch) Rollback() error {
+	return os.RemoveAll(b.j.dir)
+}
@@ -0,0 +1,115 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setup(t *testing.T) (*Journal, string, string) {
+	t.Helper()
+	root := t.TempDir()
+	objectDir := filepath.Join(root, ".encrypted")
+	metadataPath := filepath.Join(root, ".metadata.enc")
+
+	if err := os.MkdirAll(objectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(objectDir, "old"), []byte("old blob"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(metadataPath, []byte("old metadata"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return New(filepath.Join(root, ".journal"), objectDir, metadataPath), objectDir, metadataPath
+}
+
+func TestJournal(t *testing.T) {
+	t.Run("Prepared batch is rolled back", func(t *testing.T) {
+		j, objectDir, metadataPath := setup(t)
+
+		b, err := j.Begin([]string{"/watch/new.txt"})
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		os.WriteFile(b.StagePath("new"), []byte("new blob"), 0600)
+		b.Write("new")
+		b.Delete("old")
+
+		// Simulate a crash: reopen the journal and recover.
+		pending, err := j.Pending()
+		if err != nil || pending == nil {
+			t.Fatalf("Expected pending batch, got %v, %v", pending, err)
+		}
+		if pending.State != StatePrepared {
+			t.Fatalf("Expected prepared state, got %s", pending.State)
+		}
+		if len(pending.Sources) != 1 || pending.Sources[0] != "/watch/new.txt" {
+			t.Errorf("Expected the sources to be recorded, got %v", pending.Sources)
+		}
+		if err := pending.Rollback(); err != nil {
+			t.Fatalf("Rollback failed: %v", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(objectDir, "new")); !os.IsNotExist(err) {
+			t.Error("Staged blob should not reach the object directory")
+		}
+		if _, err := os.Stat(filepath.Join(objectDir, "old")); err != nil {
+			t.Error("Old blob should survive a rollback")
+		}
+		if data, _ := os.ReadFile(metadataPath); string(data) != "old metadata" {
+			t.Errorf("Metadata changed on rollback: %q", data)
+		}
+		if p, _ := j.Pending(); p != nil {
+			t.Error("Journal should be empty after rollback")
+		}
+	})
+
+	t.Run("Committed batch is replayed", func(t *testing.T) {
+		j, objectDir, metadataPath := setup(t)
+
+		b, err := j.Begin(nil)
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		os.WriteFile(b.StagePath("new"), []byte("new blob"), 0600)
+		b.Write("new")
+		b.Delete("old")
+
+		if err := b.Commit(); err == nil {
+			t.Fatal("Commit should fail without staged metadata")
+		}
+		os.WriteFile(b.MetadataPath(), []byte("new metadata"), 0600)
+		if err := b.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+
+		if _, err := j.Begin(nil); err != ErrBatchPending {
+			t.Errorf("Expected ErrBatchPending, got %v", err)
+		}
+
+		pending, err := j.Pending()
+		if err != nil || pending == nil || pending.State != StateCommitted {
+			t.Fatalf("Expected committed batch, got %v, %v", pending, err)
+		}
+		// Applying twice must be harmless, as a crash can happen mid-apply.
+		for i := 0; i < 2; i++ {
+			if err := pending.Apply(); err != nil {
+				t.Fatalf("Apply %d failed: %v", i, err)
+			}
+		}
+		if err := pending.Finish(); err != nil {
+			t.Fatalf("Finish failed: %v", err)
+		}
+
+		if data, _ := os.ReadFile(filepath.Join(objectDir, "new")); string(data) != "new blob" {
+			t.Errorf("Expected new blob, got %q", data)
+		}
+		if _, err := os.Stat(filepath.Join(objectDir, "old")); !os.IsNotExist(err) {
+			t.Error("Old blob should be removed")
+		}
+		if data, _ := os.ReadFile(metadataPath); string(data) != "new metadata" {
+			t.Errorf("Expected new metadata, got %q", data)
+		}
+	})
+}
@@ -0,0 +1,221 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"git-fs/internal/logging"
+	"git-fs/internal/process"
+
+	"go.uber.org/zap"
+)
+
+// FileName is the lock file created in the repository root.
+const FileName = ".git-fs.lock"
+
+// TakeoverFileName is held briefly while a stale lock is taken over.
+const TakeoverFileName = ".git-fs.lock.takeover"
+
+// staleAfter is how old a lock file that can't be read, or a takeover
+// lock, must be before it is considered left behind by a crash.
+const staleAfter = 10 * time.Second
+
+var ErrLocked = errors.New("repository is locked")
+
+// Info identifies the process holding the lock.
+type Info struct {
+	PID      int       `json:"pid"`
+	Host     string    `json:"host"`
+	Command  string    `json:"command"`
+	Acquired time.Time `json:"acquired"`
+}
+
+// LockedError is returned when another live process holds the lock.
+type LockedError struct {
+	Path   string
+	Holder Info
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("repository is locked by `git-fs %s` (pid %d on host %s) since %s; if that process is gone, remove %s",
+		e.Holder.Command, e.Holder.PID, e.Holder.Host, e.Holder.Acquired.Format(time.RFC3339), e.Path)
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Lock is an advisory lock on a repository, held for the lifetime of a
+// mutating command.
+type Lock struct {
+	path string
+	info Info
+}
+
+// Acquire takes the lock for repoPath on behalf of command. A lock left
+// behind by a process that no longer exists on this host is taken over.
+//
+// The lock file is written in full before it is linked into place, so it is
+// never seen half written. Taking over a stale lock happens under a second
+// lock, and only once it is seen to be stale while holding that, so that
+// two processes can't both take it over.
+func Acquire(repoPath, command string) (*Lock, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lock{
+		path: filepath.Join(repoPath, FileName),
+		info: Info{PID: os.Getpid(), Host: host, Command: command, Acquired: time.Now()},
+	}
+	tmp, err := writeInfo(repoPath, l.info)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	// The second attempt only happens after a stale lock was removed.
+	for attempt := 0; attempt < 2; attempt++ {
+		err := os.Link(tmp, l.path)
+		if err == nil {
+			return l, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if err := l.takeOver(repoPath, host); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: could not acquire %s", ErrLocked, l.path)
+}
+
+// takeOver removes the lock file if it is stale, and otherwise returns why
+// the lock can't be taken.
+func (l *Lock) takeOver(repoPath, host string) error {
+	if _, _, err := checkStale(l.path, host); err != nil {
+		return err
+	}
+
+	// Check again while holding the takeover lock: another process may have
+	// taken over the stale lock since.
+	takeover := filepath.Join(repoPath, TakeoverFileName)
+	tmp, err := writeInfo(repoPath, l.info)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := os.Link(tmp, takeover); err != nil {
+		if !os.IsExist(err) {
+			return err
+		}
+		// Left behind by a process that died while taking over.
+		if info, serr := os.Stat(takeover); serr != nil || time.Since(info.ModTime()) < staleAfter {
+			return fmt.Errorf("%w: another process is taking over %s", ErrLocked, l.path)
+		}
+		logging.Logger.Warn("Removing stale takeover lock", zap.String("path", takeover))
+		if err := os.Remove(takeover); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Link(tmp, takeover); err != nil {
+			return fmt.Errorf("%w: another process is taking over %s", ErrLocked, l.path)
+		}
+	}
+	defer os.Remove(takeover)
+
+	holder, present, err := checkStale(l.path, host)
+	if err != nil || !present {
+		return err
+	}
+	logging.Logger.Warn("Removing stale lock",
+		zap.String("path", l.path),
+		zap.Int("pid", holder.PID),
+		zap.String("command", holder.Command))
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// checkStale returns the holder of the lock file at path, and whether
+// there is one, if it is stale or gone, or else an error saying who holds
+// it. A lock file that can't be read is
+// stale once it is older than staleAfter; a newer one may still be written
+// by a version that didn't write it in one go.
+func checkStale(path, host string) (Info, bool, error) {
+	holder, err := readInfo(path)
+	if os.IsNotExist(err) {
+		return holder, false, nil
+	}
+	if err != nil {
+		info, serr := os.Stat(path)
+		if serr == nil && time.Since(info.ModTime()) >= staleAfter {
+			return holder, true, nil
+		}
+		return holder, true, fmt.Errorf("%w: unreadable lock file %s: %v", ErrLocked, path, err)
+	}
+	if !isStale(holder, host) {
+		return holder, true, &LockedError{Path: path, Holder: holder}
+	}
+	return holder, true, nil
+}
+
+// writeInfo writes info to a new temporary file in dir and returns its path.
+func writeInfo(dir string, info Info) (string, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, FileName+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Release removes the lock if it is still owned by this process.
+func (l *Lock) Release() error {
+	holder, err := readInfo(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if holder.PID != l.info.PID || holder.Host != l.info.Host {
+		return nil
+	}
+	return os.Remove(l.path)
+}
+
+func readInfo(path string) (Info, error) {
+	var info Info
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// isStale reports whether the holder is known to be gone. Locks held on other
+// hosts cannot be checked and are never considered stale.
+func isStale(holder Info, host string) bool {
+	return holder.Host == host && !process.Alive(holder.PID)
+}
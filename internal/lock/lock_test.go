@@ -0,0 +1,149 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git-fs/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+func TestLock(t *testing.T) {
+	logging.Logger = zap.NewNop()
+
+	t.Run("Second acquire names the holder", func(t *testing.T) {
+		repo := t.TempDir()
+
+		l, err := Acquire(repo, "daemon")
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+
+		_, err = Acquire(repo, "decrypt")
+		if !errors.Is(err, ErrLocked) {
+			t.Fatalf("Expected ErrLocked, got %v", err)
+		}
+		var locked *LockedError
+		if !errors.As(err, &locked) || locked.Holder.PID != os.Getpid() || locked.Holder.Command != "daemon" {
+			t.Errorf("Expected holder to be this daemon, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "git-fs daemon") {
+			t.Errorf("Error should name the holding command: %v", err)
+		}
+
+		if err := l.Release(); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+		l, err = Acquire(repo, "decrypt")
+		if err != nil {
+			t.Fatalf("Acquire after release failed: %v", err)
+		}
+		l.Release()
+	})
+
+	t.Run("Stale lock is taken over", func(t *testing.T) {
+		repo := t.TempDir()
+
+		if err := os.WriteFile(filepath.Join(repo, FileName), staleLock(t), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := Acquire(repo, "daemon")
+		if err != nil {
+			t.Fatalf("Expected stale lock to be replaced, got %v", err)
+		}
+		l.Release()
+	})
+
+	t.Run("Unreadable lock is taken over once old", func(t *testing.T) {
+		repo := t.TempDir()
+
+		// Left empty by a crash right after creating it.
+		path := filepath.Join(repo, FileName)
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Acquire(repo, "daemon"); !errors.Is(err, ErrLocked) {
+			t.Fatalf("Expected ErrLocked for a new lock file, got %v", err)
+		}
+
+		old := time.Now().Add(-time.Minute)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		l, err := Acquire(repo, "daemon")
+		if err != nil {
+			t.Fatalf("Expected unreadable lock to be replaced, got %v", err)
+		}
+		l.Release()
+	})
+
+	t.Run("Concurrent takeover", func(t *testing.T) {
+		stale := staleLock(t)
+
+		// The race between two takeovers is narrow; give it many chances.
+		for range 50 {
+			repo := t.TempDir()
+			if err := os.WriteFile(filepath.Join(repo, FileName), stale, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			const n = 8
+			var wg sync.WaitGroup
+			locks := make(chan *Lock, n)
+			for range n {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					l, err := Acquire(repo, "daemon")
+					if err == nil {
+						locks <- l
+					} else if !errors.Is(err, ErrLocked) {
+						t.Errorf("Expected ErrLocked, got %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+			close(locks)
+
+			if len(locks) != 1 {
+				t.Fatalf("%d processes took over the lock, want 1", len(locks))
+			}
+			(<-locks).Release()
+			if entries, _ := os.ReadDir(repo); len(entries) != 0 {
+				t.Fatalf("Files left behind: %v", entries)
+			}
+		}
+	})
+
+	t.Run("Lock from another host is respected", func(t *testing.T) {
+		repo := t.TempDir()
+
+		data, _ := json.Marshal(Info{PID: 1, Host: "elsewhere.invalid", Command: "daemon", Acquired: time.Now()})
+		os.WriteFile(filepath.Join(repo, FileName), data, 0600)
+
+		if _, err := Acquire(repo, "daemon"); !errors.Is(err, ErrLocked) {
+			t.Fatalf("Expected ErrLocked, got %v", err)
+		}
+	})
+}
+
+// staleLock returns the content of a lock held by a process that has exited.
+func staleLock(t *testing.T) []byte {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(Info{PID: cmd.Process.Pid, Host: host, Command: "daemon", Acquired: time.Now()})
+	return data
+}
@@ -18,7 +18,8 @@ func InitLogger(debug bool) error {
 	cfg.EncoderConfig.LevelKey = "level"
 	cfg.EncoderConfig.CallerKey = "caller"
 	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	cfg.OutputPaths = []string{"stdout"}
+	// Logs go to stderr so that stdout stays clean for machine-readable output.
+	cfg.OutputPaths = []string{"stderr"}
 
 	logger, err := cfg.Build()
 	if err != nil {
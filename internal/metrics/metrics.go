@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gitfs"
+
+// Results used as the "result" label of the commit and push counters.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	EventsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "File system events received by the watcher.",
+	})
+	Rescans = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rescans_total",
+		Help:      "Rescans of the watch path after the watcher lost events.",
+	})
+	FilesMoved = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_moved_total",
+		Help:      "Renamed or moved files recorded without encrypting them again.",
+	})
+	FilesUpdated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_updated_total",
+		Help:      "Files whose attributes changed but not their content.",
+	})
+	FilesUnchanged = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_unchanged_total",
+		Help:      "Reported files skipped because their content had not changed.",
+	})
+	FilesEncrypted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_encrypted_total",
+		Help:      "Files encrypted into the repository.",
+	})
+	BytesIn = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plaintext_bytes_total",
+		Help:      "Plaintext bytes read from the watched directory.",
+	})
+	BytesOut = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "encrypted_bytes_total",
+		Help:      "Encrypted bytes written to the repository.",
+	})
+	BatchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_duration_seconds",
+		Help:      "Time taken to encrypt and commit a batch of changes.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	})
+	Commits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commits_total",
+		Help:      "Git commits attempted, by result.",
+	}, []string{"result"})
+	Pushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pushes_total",
+		Help:      "Git pushes attempted, by result.",
+	}, []string{"result"})
+	LastSuccessfulPush = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_push_timestamp_seconds",
+		Help:      "Unix time of the last successful push.",
+	})
+	UnpushedCommits = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "unpushed_commits",
+		Help:      "Local commits not yet pushed to the remote.",
+	})
+	MetadataEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "metadata_entries",
+		Help:      "Files tracked in the metadata store.",
+	})
+	MetadataBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "metadata_store_bytes",
+		Help:      "Size of the encrypted metadata store.",
+	})
+)
+
+// Registry holds the git-fs metrics plus the standard Go and process collectors.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		EventsReceived, Rescans, FilesEncrypted, FilesMoved, FilesUpdated, FilesUnchanged, BytesIn, BytesOut, BatchDuration,
+		Commits, Pushes, LastSuccessfulPush, UnpushedCommits, MetadataEntries, MetadataBytes,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	// Make both results visible before the first commit or push.
+	for _, result := range []string{ResultSuccess, ResultFailure} {
+		Commits.WithLabelValues(result)
+		Pushes.WithLabelValues(result)
+	}
+}
+
+// Server exposes the registry on /metrics.
+type Server struct {
+	srv *http.Server
+	ln  net.Listener
+}
+
+// Listen binds addr, such as "127.0.0.1:9273", for the metrics endpoint.
+func Listen(addr string) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	return &Server{
+		srv: &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		ln:  ln,
+	}, nil
+}
+
+// Serve handles requests until Close is called.
+func (s *Server) Serve() error {
+	if err := s.srv.Serve(s.ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the server, letting in-flight scrapes finish.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
+}
@@ -0,0 +1,267 @@
+// Package pack bundles small encrypted objects into pack files, so that the
+// object directory reveals neither how many files there are nor which of
+// them change together, and git has fewer objects to track.
+//
+// A pack file is laid out as
+//
+//	magic   4 bytes
+//	blobs   the sealed objects, back to back
+//	filler  random bytes padding the file to the size the padding scheme picks
+//	index   the sealed Index, naming each object and where its blob is
+//	length  4 bytes, big endian: the size of the sealed index
+//
+// The blobs are stored exactly as they would be on their own, so they stay
+// bound to their object ids. The index is bound to the name of the pack.
+//
+// Every batch of changes writes packs of its own, so their number and times
+// follow the commits, which are visible anyway; padding keeps their sizes
+// from telling how much each batch changed.
+package pack
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"git-fs/internal/crypto"
+)
+
+const (
+	// MaxObjectSize is the largest blob that is packed; larger ones are
+	// stored on their own.
+	MaxObjectSize = 64 << 10
+	// MaxSize bounds the size of a pack file. Packs are cut before an
+	// object would take them over it.
+	MaxSize = 8 << 20
+
+	magic      = "GFSP"
+	nameSuffix = ".pack"
+	lengthSize = 4
+)
+
+// ErrNotFound is returned for an object that isn't in the pack.
+var ErrNotFound = errors.New("object not in pack")
+
+// Entry locates the blob of one object in a pack.
+type Entry struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// NewName returns a random name for a new pack file.
+func NewName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b) + nameSuffix, nil
+}
+
+// IsName reports whether name, in the object directory, is a pack file.
+// Object ids are URL-safe base64, which has no dots, so they never look
+// like one.
+func IsName(name string) bool {
+	return strings.HasSuffix(name, nameSuffix)
+}
+
+// indexID is the object id the index of the pack called name is sealed under.
+func indexID(name string) string {
+	return name + "/index"
+}
+
+// Writer builds a pack file in memory.
+type Writer struct {
+	Name    string
+	buf     bytes.Buffer
+	entries []Entry
+}
+
+// NewWriter starts a pack file called name.
+func NewWriter(name string) *Writer {
+	w := &Writer{Name: name}
+	w.buf.WriteString(magic)
+	return w
+}
+
+// Add appends the sealed blob of object id.
+func (w *Writer) Add(id string, blob []byte) {
+	w.entries = append(w.entries, Entry{ID: id, Offset: int64(w.buf.Len()), Length: int64(len(blob))})
+	w.buf.Write(blob)
+}
+
+// Size returns the size of the blobs added so far, including the magic.
+func (w *Writer) Size() int {
+	return w.buf.Len()
+}
+
+// Len returns the number of objects added.
+func (w *Writer) Len() int {
+	return len(w.entries)
+}
+
+// Fits reports whether a blob of size bytes can still be added without
+// taking the pack over MaxSize. An empty pack takes any blob.
+func (w *Writer) Fits(size int) bool {
+	return w.Len() == 0 || w.Size()+size <= MaxSize
+}
+
+// Finish seals the index with suite and returns the complete pack file,
+// padded to the size padding picks.
+func (w *Writer) Finish(keys *crypto.Keys, suite *crypto.Suite, padding crypto.Padding) ([]byte, error) {
+	data, err := json.Marshal(w.entries)
+	if err != nil {
+		return nil, err
+	}
+	index, err := crypto.SealBlob(keys, crypto.SealOptions{Suite: suite, Compression: crypto.DefaultCompression}, indexID(w.Name), data)
+	if err != nil {
+		return nil, err
+	}
+
+	size := w.buf.Len() + len(index) + lengthSize
+	out := make([]byte, padding.Size(size)-len(index)-lengthSize)
+	copy(out, w.buf.Bytes())
+	if _, err := rand.Read(out[w.buf.Len():]); err != nil {
+		return nil, err
+	}
+	out = append(out, index...)
+	return binary.BigEndian.AppendUint32(out, uint32(len(index))), nil
+}
+
+// Pack is a pack file read into memory.
+type Pack struct {
+	Name    string
+	Size    int64
+	data    []byte
+	entries map[string]Entry
+}
+
+// Open reads the index of data, the pack file called name.
+func Open(keys *crypto.Keys, name string, data []byte) (*Pack, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) || len(data) < len(magic)+lengthSize {
+		return nil, fmt.Errorf("%s is not a pack file", name)
+	}
+	indexLen := int64(binary.BigEndian.Uint32(data[len(data)-lengthSize:]))
+	indexStart := int64(len(data)-lengthSize) - indexLen
+	if indexStart < int64(len(magic)) {
+		return nil, fmt.Errorf("pack %s has an invalid index length", name)
+	}
+
+	index, err := crypto.OpenBlob(keys, indexID(name), data[indexStart:len(data)-lengthSize])
+	if err != nil {
+		return nil, fmt.Errorf("pack %s: %w", name, err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(index, &entries); err != nil {
+		return nil, fmt.Errorf("pack %s has a corrupt index: %w", name, err)
+	}
+
+	p := &Pack{Name: name, Size: int64(len(data)), data: data, entries: make(map[string]Entry, len(entries))}
+	for _, e := range entries {
+		if e.Offset < int64(len(magic)) || e.Length < 0 || e.Offset+e.Length > indexStart {
+			return nil, fmt.Errorf("pack %s has an invalid entry for %s", name, e.ID)
+		}
+		p.entries[e.ID] = e
+	}
+	return p, nil
+}
+
+// ReadFile reads the pack file at path.
+func ReadFile(keys *crypto.Keys, path string) (*Pack, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Open(keys, filepath.Base(path), data)
+}
+
+// Blob returns the sealed blob of object id.
+func (p *Pack) Blob(id string) ([]byte, error) {
+	e, ok := p.entries[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s in %s", ErrNotFound, id, p.Name)
+	}
+	return p.data[e.Offset : e.Offset+e.Length : e.Offset+e.Length], nil
+}
+
+// BlobBytes returns the size of all blobs in the pack, which excludes the
+// index and padding.
+func (p *Pack) BlobBytes() int64 {
+	var n int64
+	for _, e := range p.entries {
+		n += e.Length
+	}
+	return n
+}
+
+// Entries returns the entries of the pack in the order of their blobs.
+func (p *Pack) Entries() []Entry {
+	entries := make([]Entry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+	return entries
+}
+
+// readerCacheSize is how many packs a Reader keeps in memory.
+const readerCacheSize = 4
+
+// Reader reads blobs from an object directory, whether they are stored on
+// their own or in a pack. It is safe for concurrent use.
+type Reader struct {
+	dir  string
+	keys *crypto.Keys
+
+	mu    sync.Mutex
+	packs map[string]*Pack
+	order []string // Names in packs, oldest first
+}
+
+// NewReader returns a reader for the object directory dir.
+func NewReader(dir string, keys *crypto.Keys) *Reader {
+	return &Reader{dir: dir, keys: keys, packs: make(map[string]*Pack)}
+}
+
+// Blob returns the sealed blob of object id from the pack called packName,
+// or from its own file if packName is empty.
+func (r *Reader) Blob(id, packName string) ([]byte, error) {
+	if packName == "" {
+		return os.ReadFile(filepath.Join(r.dir, id))
+	}
+	p, err := r.Pack(packName)
+	if err != nil {
+		return nil, err
+	}
+	return p.Blob(id)
+}
+
+// Pack returns the pack called name, reading it unless it was read recently.
+func (r *Reader) Pack(name string) (*Pack, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.packs[name]; ok {
+		return p, nil
+	}
+	p, err := ReadFile(r.keys, filepath.Join(r.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if len(r.order) == readerCacheSize {
+		delete(r.packs, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.packs[name] = p
+	r.order = append(r.order, name)
+	return p, nil
+}
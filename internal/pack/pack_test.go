@@ -0,0 +1,134 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git-fs/internal/crypto"
+)
+
+func testKeys(t *testing.T) *crypto.Keys {
+	t.Helper()
+	keys, err := crypto.DeriveKeys(make([]byte, crypto.KeySize))
+	if err != nil {
+		t.Fatalf("DeriveKeys failed: %v", err)
+	}
+	return keys
+}
+
+func TestPack(t *testing.T) {
+	keys := testKeys(t)
+	name, err := NewName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsName(name) {
+		t.Fatalf("IsName(%q) = false", name)
+	}
+
+	blobs := map[string][]byte{"a": []byte("first blob"), "b": []byte("second, longer blob"), "c": {}}
+	w := NewWriter(name)
+	for _, id := range []string{"a", "b", "c"} {
+		w.Add(id, blobs[id])
+	}
+	data, err := w.Finish(keys, crypto.AES256GCM, crypto.PaddingNone)
+	if err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+
+	p, err := Open(keys, name, data)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for id, want := range blobs {
+		got, err := p.Blob(id)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Blob(%q) = %q, %v, want %q", id, got, err, want)
+		}
+	}
+	if _, err := p.Blob("d"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if entries := p.Entries(); len(entries) != 3 || entries[0].ID != "a" || entries[2].ID != "c" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+
+	// The index is bound to the name of the pack.
+	other, _ := NewName()
+	if _, err := Open(keys, other, data); err == nil {
+		t.Error("expected an error for a pack opened under another name")
+	}
+	tampered := bytes.Clone(data)
+	tampered[len(tampered)-lengthSize-1] ^= 1
+	if _, err := Open(keys, name, tampered); err == nil {
+		t.Error("expected an error for a changed index")
+	}
+	if _, err := Open(keys, name, data[:len(data)-1]); err == nil {
+		t.Error("expected an error for a truncated pack")
+	}
+
+	t.Run("Reader", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "loose"), []byte("loose blob"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(dir, keys)
+		if got, err := r.Blob("b", name); err != nil || !bytes.Equal(got, blobs["b"]) {
+			t.Errorf("packed blob: got %q, %v", got, err)
+		}
+		if got, err := r.Blob("loose", ""); err != nil || string(got) != "loose blob" {
+			t.Errorf("loose blob: got %q, %v", got, err)
+		}
+	})
+}
+
+func TestWriterFits(t *testing.T) {
+	w := NewWriter("test.pack")
+	if !w.Fits(MaxSize * 2) {
+		t.Error("an empty pack should take any blob")
+	}
+	w.Add("a", make([]byte, MaxSize-100))
+	if w.Fits(200) {
+		t.Error("blob should not fit")
+	}
+	if !w.Fits(50) {
+		t.Error("blob should fit")
+	}
+}
+
+func TestPackPadding(t *testing.T) {
+	keys := testKeys(t)
+	w := NewWriter("padded.pack")
+	w.Add("a", bytes.Repeat([]byte{1}, 1000))
+	w.Add("b", bytes.Repeat([]byte{2}, 3000))
+	unpadded, err := w.Finish(keys, crypto.AES256GCM, crypto.PaddingNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, padding := range crypto.Paddings {
+		data, err := w.Finish(keys, crypto.AES256GCM, padding)
+		if err != nil {
+			t.Fatalf("%s: Finish failed: %v", padding, err)
+		}
+		if want := padding.Size(len(unpadded)); len(data) != want {
+			t.Errorf("%s: pack is %d bytes, want %d", padding, len(data), want)
+		}
+		p, err := Open(keys, w.Name, data)
+		if err != nil {
+			t.Fatalf("%s: Open failed: %v", padding, err)
+		}
+		if got, err := p.Blob("b"); err != nil || !bytes.Equal(got, bytes.Repeat([]byte{2}, 3000)) {
+			t.Errorf("%s: Blob(b) = %d bytes, %v", padding, len(got), err)
+		}
+		if p.BlobBytes() != 4000 {
+			t.Errorf("%s: BlobBytes() = %d, want 4000", padding, p.BlobBytes())
+		}
+	}
+}
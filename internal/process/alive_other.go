@@ -0,0 +1,9 @@
+//go:build !unix
+
+package process
+
+// Alive cannot probe other processes on this platform, so every pid is
+// assumed to be running.
+func Alive(pid int) bool {
+	return true
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package process
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errUnsupported = errors.New("detaching is not supported on this platform")
+
+// Detach is not supported on this platform.
+func Detach(args []string, logPath string) (*exec.Cmd, error) {
+	return nil, errUnsupported
+}
+
+// Terminate is not supported on this platform.
+func Terminate(pid int) error {
+	return errUnsupported
+}
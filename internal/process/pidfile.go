@@ -0,0 +1,55 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	fileutils "git-fs/internal/fileutil"
+)
+
+var ErrNotRunning = errors.New("process is not running")
+
+// WritePIDFile records the current process id in path.
+func WritePIDFile(path string) error {
+	return fileutils.WriteFileAtomic(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// ReadPIDFile returns the pid recorded in path. A missing pidfile or one
+// naming a process that has exited yields ErrNotRunning; the latter is removed.
+func ReadPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, ErrNotRunning
+		}
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pidfile %q: %w", path, err)
+	}
+	if !Alive(pid) {
+		os.Remove(path)
+		return 0, ErrNotRunning
+	}
+	return pid, nil
+}
+
+// RemovePIDFile removes path if it still names the current process.
+func RemovePIDFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,261 @@
+// Package restore decrypts the files recorded in a metadata store back into
+// a directory.
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"git-fs/internal/crypto"
+	"git-fs/internal/fileattr"
+	filemetadata "git-fs/internal/filemetadata"
+	fileutils "git-fs/internal/fileutil"
+	"git-fs/internal/logging"
+	"git-fs/internal/pack"
+
+	"go.uber.org/zap"
+)
+
+// ErrFingerprint is returned for decrypted content that doesn't match the
+// fingerprint recorded for it.
+var ErrFingerprint = errors.New("decrypted content does not match its fingerprint")
+
+// Options configure a Restorer.
+type Options struct {
+	Keys      *crypto.Keys
+	ObjectDir string // The .encrypted directory
+	OutputDir string
+
+	// Concurrency is how many files are decrypted at once; zero means one
+	// per CPU.
+	Concurrency int
+	// Resume skips files whose content in OutputDir already matches their
+	// fingerprint, such as those restored by an interrupted run.
+	Resume bool
+	// Owner restores the owner and group recorded for files, which takes
+	// root unless they belong to the current user.
+	Owner bool
+}
+
+// Progress counts the files of a restore, and their plaintext bytes.
+type Progress struct {
+	Files      int
+	TotalFiles int
+	Bytes      int64
+	TotalBytes int64
+	Skipped    int // Already restored; included in Files
+	Failed     int // Included in Files
+	Started    time.Time
+}
+
+// ETA estimates how long the rest of the restore takes at the rate so far.
+func (p Progress) ETA() time.Duration {
+	elapsed := time.Since(p.Started)
+	if p.Bytes == 0 || elapsed <= 0 {
+		return 0
+	}
+	rate := float64(p.Bytes) / elapsed.Seconds()
+	return time.Duration(float64(p.TotalBytes-p.Bytes) / rate * float64(time.Second))
+}
+
+// Failure is a file that couldn't be restored.
+type Failure struct {
+	Path string
+	Err  error
+}
+
+// Result is the outcome of Run.
+type Result struct {
+	Progress
+	Failures []Failure
+	// Interrupted is set if the context was cancelled before all files
+	// were restored.
+	Interrupted bool
+}
+
+// Restorer restores the files of one metadata store.
+type Restorer struct {
+	store   *filemetadata.MetadataStore
+	opts    Options
+	objects *pack.Reader
+
+	mu       sync.Mutex
+	progress Progress
+	failures []Failure
+}
+
+// New returns a restorer for the files in store.
+func New(store *filemetadata.MetadataStore, opts Options) *Restorer {
+	return &Restorer{store: store, opts: opts, objects: pack.NewReader(opts.ObjectDir, opts.Keys)}
+}
+
+// Progress returns how far Run got. It is safe to call while Run is running.
+func (r *Restorer) Progress() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress
+}
+
+// Run restores every file, up to Concurrency at a time, until done or ctx
+// is cancelled. Files are written atomically, so an interrupted run leaves
+// no partial files and can be resumed. Failures don't stop the others.
+func (r *Restorer) Run(ctx context.Context) *Result {
+	r.store.Mu.RLock()
+	names := make([]string, 0, len(r.store.Metadata))
+	files := make(map[string]filemetadata.FileMetadata, len(r.store.Metadata))
+	var total int64
+	for encName, metadata := range r.store.Metadata {
+		names = append(names, encName)
+		files[encName] = metadata
+		total += metadata.FileSize
+	}
+	r.store.Mu.RUnlock()
+
+	// Pack by pack, so each pack is only read once.
+	sort.Slice(names, func(i, j int) bool {
+		a, b := files[names[i]], files[names[j]]
+		return a.Pack < b.Pack || a.Pack == b.Pack && names[i] < names[j]
+	})
+
+	r.mu.Lock()
+	r.progress = Progress{TotalFiles: len(names), TotalBytes: total, Started: time.Now()}
+	r.mu.Unlock()
+
+	workers := r.opts.Concurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for range min(workers, len(names)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for encName := range work {
+				metadata := files[encName]
+				skipped, err := r.restoreFile(encName, metadata)
+				r.done(metadata, skipped, err)
+			}
+		}()
+	}
+
+	interrupted := false
+dispatch:
+	for _, encName := range names {
+		if ctx.Err() != nil {
+			interrupted = true
+			break
+		}
+		select {
+		case work <- encName:
+		case <-ctx.Done():
+			interrupted = true
+			break dispatch
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.Slice(r.failures, func(i, j int) bool { return r.failures[i].Path < r.failures[j].Path })
+	return &Result{Progress: r.progress, Failures: r.failures, Interrupted: interrupted}
+}
+
+func (r *Restorer) done(metadata filemetadata.FileMetadata, skipped bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.progress.Files++
+	r.progress.Bytes += metadata.FileSize
+	switch {
+	case err != nil:
+		r.progress.Failed++
+		r.failures = append(r.failures, Failure{Path: metadata.OriginalPath, Err: err})
+	case skipped:
+		r.progress.Skipped++
+	}
+}
+
+// restoreFile decrypts the file stored as encName into the output
+// directory, unless resuming and it is there already, and restores its
+// attributes.
+func (r *Restorer) restoreFile(encName string, metadata filemetadata.FileMetadata) (skipped bool, err error) {
+	logger := logging.Logger
+	outputPath := filepath.Join(r.opts.OutputDir, metadata.OriginalPath)
+
+	defer func() {
+		if err != nil {
+			logger.Error("Failed to decrypt file",
+				zap.String("encrypted_file", encName),
+				zap.String("output_path", outputPath),
+				zap.Error(err))
+		}
+	}()
+
+	if r.opts.Resume && r.restored(outputPath, metadata) {
+		logger.Debug("File already restored", zap.String("path", outputPath))
+		return true, r.restoreAttrs(outputPath, metadata)
+	}
+
+	// Create the output directory structure
+	if err := fileutils.EnsureDir(filepath.Dir(outputPath)); err != nil {
+		return false, err
+	}
+
+	blob, err := r.objects.Blob(encName, metadata.Pack)
+	if err != nil {
+		return false, err
+	}
+	content, err := metadata.Open(r.opts.Keys, blob)
+	if err != nil {
+		return false, err
+	}
+	if metadata.OriginalHash != "" && crypto.ContentMAC(r.opts.Keys.MAC, content) != metadata.OriginalHash {
+		return false, ErrFingerprint
+	}
+	if err := fileutils.WriteFileAtomic(outputPath, content, 0600); err != nil {
+		return false, err
+	}
+	if err := r.restoreAttrs(outputPath, metadata); err != nil {
+		return false, err
+	}
+
+	logger.Info("File decrypted",
+		zap.String("encrypted_file", encName),
+		zap.String("decrypted_file", outputPath))
+	return false, nil
+}
+
+// restoreAttrs gives the file at path the attributes recorded in metadata.
+func (r *Restorer) restoreAttrs(path string, metadata filemetadata.FileMetadata) error {
+	if err := fileattr.Apply(path, metadata.Attrs(), fileattr.Options{Owner: r.opts.Owner}); err != nil {
+		return fmt.Errorf("restoring attributes: %w", err)
+	}
+	return nil
+}
+
+// restored reports whether the file at path has the content metadata
+// describes. Files without a fingerprint can't be checked and are restored
+// again.
+func (r *Restorer) restored(path string, metadata filemetadata.FileMetadata) bool {
+	if metadata.OriginalHash == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != metadata.FileSize {
+		return false
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return crypto.ContentMAC(r.opts.Keys.MAC, content) == metadata.OriginalHash
+}
@@ -0,0 +1,168 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git-fs/internal/crypto"
+	"git-fs/internal/fileattr"
+	filemetadata "git-fs/internal/filemetadata"
+	"git-fs/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// newTestStore encrypts files into a fresh object directory and returns
+// their metadata and options to restore them into an empty directory.
+func newTestStore(t *testing.T, files map[string]string) (*filemetadata.MetadataStore, Options) {
+	t.Helper()
+	logging.Logger = zap.NewNop()
+
+	keys, err := crypto.DeriveKeys(make([]byte, crypto.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Keys: keys, ObjectDir: t.TempDir(), OutputDir: t.TempDir(), Concurrency: 4}
+
+	store := filemetadata.NewMetadataStore()
+	i := 0
+	for path, content := range files {
+		name := fmt.Sprint("object", i)
+		i++
+		blob, err := crypto.SealBlob(keys, crypto.SealOptions{Suite: crypto.AES256GCM}, name, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(opts.ObjectDir, name), blob, 0600); err != nil {
+			t.Fatal(err)
+		}
+		store.Put(name, filemetadata.FileMetadata{
+			EncryptedName: name,
+			OriginalPath:  path,
+			OriginalHash:  crypto.ContentMAC(keys.MAC, []byte(content)),
+			FileSize:      int64(len(content)),
+			KeyVersion:    crypto.KeyVersionSubkeys,
+			BlobFormat:    crypto.BlobFormatV4,
+		})
+	}
+	return store, opts
+}
+
+func checkRestored(t *testing.T, opts Options, files map[string]string) {
+	t.Helper()
+	for path, want := range files {
+		got, err := os.ReadFile(filepath.Join(opts.OutputDir, path))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	files := map[string]string{}
+	for i := range 20 {
+		files[filepath.Join(fmt.Sprint("dir", i%3), fmt.Sprint("file", i))] = fmt.Sprint("content ", i)
+	}
+	store, opts := newTestStore(t, files)
+
+	result := New(store, opts).Run(context.Background())
+	if result.Files != 20 || result.Failed != 0 || result.Skipped != 0 || result.Interrupted {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result.Bytes != result.TotalBytes {
+		t.Errorf("%d of %d bytes done", result.Bytes, result.TotalBytes)
+	}
+	checkRestored(t, opts, files)
+
+	t.Run("Resume", func(t *testing.T) {
+		changed := filepath.Join(opts.OutputDir, "dir0", "file0")
+		if err := os.WriteFile(changed, []byte("changed 0"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		opts.Resume = true
+		result := New(store, opts).Run(context.Background())
+		if result.Files != 20 || result.Skipped != 19 || result.Failed != 0 {
+			t.Errorf("unexpected result %+v", result)
+		}
+		checkRestored(t, opts, files)
+	})
+}
+
+func TestRunFailures(t *testing.T) {
+	files := map[string]string{"a": "content a", "b": "content b", "c": "content c"}
+	store, opts := newTestStore(t, files)
+
+	encName, _, _ := store.FindByPath("b")
+	blob, err := os.ReadFile(filepath.Join(opts.ObjectDir, encName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[len(blob)-1] ^= 1
+	if err := os.WriteFile(filepath.Join(opts.ObjectDir, encName), blob, 0600); err != nil {
+		t.Fatal(err)
+	}
+	encName, _, _ = store.FindByPath("c")
+	if err := os.Remove(filepath.Join(opts.ObjectDir, encName)); err != nil {
+		t.Fatal(err)
+	}
+
+	result := New(store, opts).Run(context.Background())
+	if result.Failed != 2 || len(result.Failures) != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result.Failures[0].Path != "b" || result.Failures[1].Path != "c" || !errors.Is(result.Failures[1].Err, os.ErrNotExist) {
+		t.Errorf("unexpected failures %v", result.Failures)
+	}
+	checkRestored(t, opts, map[string]string{"a": "content a"})
+	if _, err := os.Stat(filepath.Join(opts.OutputDir, "b")); !os.IsNotExist(err) {
+		t.Errorf("failed file was written: %v", err)
+	}
+}
+
+func TestRunAttrs(t *testing.T) {
+	store, opts := newTestStore(t, map[string]string{"script": "#!/bin/sh\n"})
+	opts.Owner = true
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	encName, metadata, _ := store.FindByPath("script")
+	metadata.SetAttrs(fileattr.Attrs{
+		Mode:    0750,
+		ModTime: modTime,
+		Owner:   &fileattr.Owner{UID: os.Getuid(), GID: os.Getgid()},
+	})
+	store.Put(encName, metadata)
+
+	for _, resume := range []bool{false, true} {
+		opts.Resume = resume
+		if result := New(store, opts).Run(context.Background()); result.Failed != 0 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+		info, err := os.Stat(filepath.Join(opts.OutputDir, "script"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != 0750 || !info.ModTime().Equal(modTime) {
+			t.Errorf("resume %v: restored with mode %v and time %v", resume, info.Mode(), info.ModTime())
+		}
+		// Resuming puts back attributes that were changed.
+		if err := os.Chmod(filepath.Join(opts.OutputDir, "script"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRunInterrupted(t *testing.T) {
+	store, opts := newTestStore(t, map[string]string{"a": "content a", "b": "content b"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := New(store, opts).Run(ctx)
+	if !result.Interrupted || result.Files == result.TotalFiles {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
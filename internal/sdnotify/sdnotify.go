@@ -0,0 +1,54 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notification states understood by systemd.
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify sends state to the service manager. It reports false without an
+// error when the process was not started by systemd with notify support.
+func Notify(state string) (bool, error) {
+	socketAddr := os.Getenv("NOTIFY_SOCKET")
+	if socketAddr == "" {
+		return false, nil
+	}
+	// Abstract namespace sockets are announced with a leading '@'.
+	if socketAddr[0] == '@' {
+		socketAddr = "\x00" + socketAddr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketAddr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often the service manager expects a
+// watchdog ping, or false if the watchdog is not enabled for this process.
+// Callers should ping at half the interval.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Unit describes a systemd user service running the git-fs daemon.
+type Unit struct {
+	Name        string
+	Description string
+	ExecStart   []string
+	WorkingDir  string
+	EnvFile     string
+	Watchdog    string // WatchdogSec value; empty disables the watchdog
+}
+
+// unitTemplate has no ordering after network-online.target, which the user
+// manager doesn't have; pushes made before the network is up are retried.
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description={{.Description}}
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart={{.Command}}
+ExecReload=/bin/kill -HUP $MAINPID
+{{- if .WorkingDir}}
+WorkingDirectory={{.WorkingDir}}
+{{- end}}
+{{- if .EnvFile}}
+EnvironmentFile={{.EnvFile}}
+{{- end}}
+Restart=on-failure
+RestartSec=10
+TimeoutStopSec=120
+{{- if .Watchdog}}
+WatchdogSec={{.Watchdog}}
+{{- end}}
+
+[Install]
+WantedBy=default.target
+`))
+
+// Render returns the unit file contents.
+func (u *Unit) Render() ([]byte, error) {
+	var buf bytes.Buffer
+	err := unitTemplate.Execute(&buf, struct {
+		*Unit
+		Command string
+	}{u, quoteCommand(u.ExecStart)})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UserUnitPath returns where systemd looks for the user unit called name.
+func UserUnitPath(name string) (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "systemd", "user", name+".service"), nil
+}
+
+// quoteCommand quotes arguments for an Exec= line so paths with spaces or
+// specifier characters survive.
+func quoteCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		arg = strings.ReplaceAll(arg, "%", "%%")
+		if strings.ContainsAny(arg, " \t\"'\\") {
+			arg = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
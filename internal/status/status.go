@@ -2,17 +2,60 @@ package status
 
 import (
 	"encoding/json"
+	fileutils "git-fs/internal/fileutil"
 	"os"
+	"sync"
 	"time"
 )
 
 type Status struct {
 	WatcherRunning     bool      `json:"watcher_running"`
+	Watcher            string    `json:"watcher,omitempty"` // fsnotify or poll; empty when only snapshots run
 	FilesPending       int       `json:"files_pending"`
 	LastCommitHash     string    `json:"last_commit_hash,omitempty"`
 	LastCommitTime     time.Time `json:"last_commit_time,omitempty"`
 	LastPushSuccessful bool      `json:"last_push_successful"`
 	LastPushTime       time.Time `json:"last_push_time,omitempty"`
+	Paused             bool      `json:"paused"`
+
+	UnpushedCommits int       `json:"unpushed_commits"`
+	PushFailures    int       `json:"push_failures"` // Consecutive failed pushes
+	NextPushAttempt time.Time `json:"next_push_attempt,omitempty"`
+
+	LastSnapshot time.Time `json:"last_snapshot,omitempty"`
+	NextSnapshot time.Time `json:"next_snapshot,omitempty"`
+
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	QueueDepth int       `json:"queue_depth"` // Changed paths waiting for the next batch
+
+	// Progress of the batch being processed, if any
+	BatchFiles     int       `json:"batch_files"`
+	BatchBytes     int64     `json:"batch_bytes"`
+	BatchFilesDone int       `json:"batch_files_done"`
+	BatchBytesDone int64     `json:"batch_bytes_done"`
+	BatchStarted   time.Time `json:"batch_started,omitempty"`
+	CurrentFile    string    `json:"current_file,omitempty"`
+	CurrentBytes   int64     `json:"current_bytes"`
+	BytesPerSecond float64   `json:"bytes_per_second"`
+
+	FilesProtected int   `json:"files_protected"`
+	BytesProtected int64 `json:"bytes_protected"`
+
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+}
+
+// Uptime returns how long the daemon has been running.
+func (st *Status) Uptime() time.Duration {
+	if st.StartedAt.IsZero() || !st.WatcherRunning {
+		return 0
+	}
+	return time.Since(st.StartedAt)
+}
+
+// InBatch reports whether a batch is being processed.
+func (st *Status) InBatch() bool {
+	return !st.BatchStarted.IsZero()
 }
 
 func LoadStatus(path string) (*Status, error) {
@@ -27,10 +70,75 @@ func LoadStatus(path string) (*Status, error) {
 	return &st, nil
 }
 
+// SaveStatus writes st to path, readable only by the owner since it names
+// files being backed up.
 func SaveStatus(path string, st *Status) error {
 	data, err := json.MarshalIndent(st, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0644)
+	return fileutils.WriteFileAtomic(path, data, 0600)
+}
+
+// Tracker guards a Status shared between goroutines and saves it to disk,
+// at most once per interval so that busy batches don't turn into a write
+// per file.
+type Tracker struct {
+	mu       sync.Mutex
+	st       Status
+	path     string
+	interval time.Duration
+	lastSave time.Time
+	pending  *time.Timer
+}
+
+func NewTracker(path string, interval time.Duration) *Tracker {
+	return &Tracker{path: path, interval: interval}
+}
+
+// Update applies fn to the status. The change is saved right away if the
+// last save is older than the interval and otherwise shortly after.
+func (t *Tracker) Update(fn func(st *Status)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fn(&t.st)
+
+	wait := t.interval - time.Since(t.lastSave)
+	if wait <= 0 {
+		return t.saveLocked()
+	}
+	if t.pending == nil {
+		t.pending = time.AfterFunc(wait, func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.pending = nil
+			t.saveLocked()
+		})
+	}
+	return nil
+}
+
+// Save writes the status immediately.
+func (t *Tracker) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending != nil {
+		t.pending.Stop()
+		t.pending = nil
+	}
+	return t.saveLocked()
+}
+
+func (t *Tracker) saveLocked() error {
+	t.lastSave = time.Now()
+	return SaveStatus(t.path, &t.st)
+}
+
+// Snapshot returns a copy of the current status.
+func (t *Tracker) Snapshot() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.st
 }
@@ -0,0 +1,68 @@
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func loadPending(t *testing.T, path string) int {
+	t.Helper()
+	st, err := LoadStatus(path)
+	if err != nil {
+		t.Fatalf("LoadStatus failed: %v", err)
+	}
+	return st.FilesPending
+}
+
+func TestTrackerThrottlesSaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	interval := 200 * time.Millisecond
+	tracker := NewTracker(path, interval)
+
+	// The first update is saved right away.
+	if err := tracker.Update(func(st *Status) { st.FilesPending = 1 }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got := loadPending(t, path); got != 1 {
+		t.Fatalf("expected the first update to be saved, got %d pending", got)
+	}
+
+	// Updates within the interval wait for it to pass.
+	tracker.Update(func(st *Status) { st.FilesPending = 2 })
+	tracker.Update(func(st *Status) { st.FilesPending = 3 })
+	if got := loadPending(t, path); got != 1 {
+		t.Errorf("expected updates within the interval to wait, got %d pending", got)
+	}
+	deadline := time.Now().Add(5 * interval)
+	for loadPending(t, path) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("throttled update not saved after the interval, got %d pending", loadPending(t, path))
+		}
+		time.Sleep(interval / 10)
+	}
+
+	// Save doesn't wait.
+	tracker.Update(func(st *Status) { st.FilesPending = 4 })
+	if err := tracker.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if got := loadPending(t, path); got != 4 {
+		t.Errorf("expected Save to write immediately, got %d pending", got)
+	}
+}
+
+func TestSaveStatusMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	if err := SaveStatus(path, &Status{CurrentFile: "private.txt"}); err != nil {
+		t.Fatalf("SaveStatus failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("expected mode 0600, got %o", mode)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package watcher
+
+import "syscall"
+
+// Magic numbers from statfs(2) of filesystems where changes made by other
+// machines, or by the kernel on behalf of a FUSE server, never show up as
+// inotify events.
+var remoteFSTypes = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x65735546: "fuse",
+	0x01021997: "9p",
+	0x00c36400: "ceph",
+	0x47504653: "gpfs",
+	0x0bd00bd0: "lustre",
+}
+
+// remoteFS returns the name of the filesystem holding path if it is one
+// that fsnotify can't be relied on for.
+func remoteFS(path string) (string, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return "", false
+	}
+	name, ok := remoteFSTypes[uint32(st.Type)]
+	return name, ok
+}
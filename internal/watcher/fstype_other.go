@@ -0,0 +1,9 @@
+//go:build !linux
+
+package watcher
+
+// remoteFS can't tell filesystems apart on this platform, so auto mode
+// relies on fsnotify failing to set up.
+func remoteFS(path string) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package watcher
+
+import "os"
+
+// inode is not available on this platform; size and mtime have to do.
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package watcher
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode returns the inode number of info, so that a file replaced by
+// another of the same size and mtime is still noticed.
+func inode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package watcher
+
+import (
+	"fmt"
+
+	"git-fs/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// checkWatchLimit warns when the watched tree takes most of the inotify
+// watches available to the user. Other programs need watches too, and once
+// they run out, new directories can no longer be watched.
+func checkWatchLimit(dirs int) {
+	limit, ok := maxUserWatches()
+	if !ok || dirs*10 < limit*8 {
+		return
+	}
+	logging.Logger.Warn("The watch path uses most of the available inotify watches; changes in new directories may be missed",
+		zap.Int("directories", dirs),
+		zap.Int("max_user_watches", limit),
+		zap.String("fix", watchLimitFix(dirs)))
+}
+
+// warnWatchLimit explains that the inotify watches ran out after dirs
+// directories were watched.
+func warnWatchLimit(dirs int) {
+	limit, _ := maxUserWatches()
+	logging.Logger.Warn("Out of inotify watches; directories beyond the limit are rescanned periodically instead of watched",
+		zap.Int("directories", dirs),
+		zap.Int("max_user_watches", limit),
+		zap.String("fix", watchLimitFix(dirs)))
+}
+
+func watchLimitFix(dirs int) string {
+	return fmt.Sprintf("sudo sysctl fs.inotify.max_user_watches=%d, or set watcher: poll", max(2*dirs, 524288))
+}
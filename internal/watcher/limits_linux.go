@@ -0,0 +1,31 @@
+//go:build linux
+
+package watcher
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const maxUserWatchesPath = "/proc/sys/fs/inotify/max_user_watches"
+
+// maxUserWatches returns the per-user limit of inotify watches.
+func maxUserWatches() (int, bool) {
+	data, err := os.ReadFile(maxUserWatchesPath)
+	if err != nil {
+		return 0, false
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, false
+	}
+	return limit, true
+}
+
+// isWatchLimit reports whether err means no more inotify watches are available.
+func isWatchLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
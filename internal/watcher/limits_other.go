@@ -0,0 +1,12 @@
+//go:build !linux
+
+package watcher
+
+// maxUserWatches is only known on Linux.
+func maxUserWatches() (int, bool) {
+	return 0, false
+}
+
+func isWatchLimit(err error) bool {
+	return false
+}
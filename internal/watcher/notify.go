@@ -0,0 +1,222 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"git-fs/internal/logging"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// notifyWatcher watches a tree with fsnotify, adding a watch for every
+// directory since inotify is not recursive.
+type notifyWatcher struct {
+	w    *fsnotify.Watcher
+	root string
+	// watched holds the directories with a watch, so that the watches of
+	// a directory renamed away can be dropped.
+	watched map[string]struct{}
+	// unwatched holds directories that could not be watched. They are
+	// retried, and rescanned meanwhile, every interval.
+	unwatched []string
+	// limitWarned is set once running out of inotify watches was logged.
+	limitWarned bool
+	interval    time.Duration
+	events      chan string
+	errors      chan error
+	done        chan struct{}
+	wg          sync.WaitGroup
+}
+
+func newNotifyWatcher(root string, interval time.Duration) (*notifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	nw := &notifyWatcher{
+		w:        w,
+		root:     root,
+		watched:  make(map[string]struct{}),
+		interval: interval,
+		events:   make(chan string),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+	// Nothing reads the errors channel yet; directories that can't be
+	// watched are left to the retries in run, which rescan them too.
+	failed, err := nw.addTree(root, false)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	nw.unwatched = failed
+	if !nw.limitWarned {
+		checkWatchLimit(len(nw.watched))
+	}
+
+	nw.wg.Add(1)
+	go nw.run()
+	return nw, nil
+}
+
+// addTree watches dir and every directory below it. With report set, the
+// files found are sent as events, since they may have been created before
+// their directory was watched. Only a failure to watch dir itself is
+// returned; the directories below it that couldn't be watched or read are
+// logged and returned in failed, for the caller to rescan and retry.
+func (nw *notifyWatcher) addTree(dir string, report bool) (failed []string, err error) {
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() {
+			err = nw.w.Add(path)
+			if err == nil {
+				nw.watched[path] = struct{}{}
+				return nil
+			}
+		}
+		if err != nil {
+			if path == dir {
+				if isWatchLimit(err) {
+					err = fmt.Errorf("%w (inotify watch limit reached; %s)", err, watchLimitFix(len(nw.watched)))
+				}
+				return err
+			}
+			// Gone since its parent was read; the parent's events cover it.
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if !isWatchLimit(err) {
+				logging.Logger.Warn("Directory can't be watched, rescanning it periodically instead",
+					zap.String("path", path), zap.Error(err))
+			} else if !nw.limitWarned {
+				nw.limitWarned = true
+				warnWatchLimit(len(nw.watched))
+			}
+			failed = append(failed, path)
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if report {
+			nw.send(path)
+		}
+		return nil
+	})
+	return failed, err
+}
+
+// unwatchTree removes the watches of dir and the directories below it.
+// inotify watches follow a renamed directory, and fsnotify gets its event
+// paths wrong once the same directory is watched under its new name too.
+func (nw *notifyWatcher) unwatchTree(dir string) {
+	if _, ok := nw.watched[dir]; !ok {
+		return
+	}
+	for path := range nw.watched {
+		if under(path, []string{dir}) {
+			nw.w.Remove(path)
+			delete(nw.watched, path)
+		}
+	}
+}
+
+func (nw *notifyWatcher) run() {
+	defer nw.wg.Done()
+	defer close(nw.events)
+	defer close(nw.errors)
+
+	retry := time.NewTicker(nw.interval)
+	defer retry.Stop()
+
+	for {
+		select {
+		case event, ok := <-nw.w.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename|fsnotify.Chmod) == 0 {
+				continue
+			}
+			if event.Has(fsnotify.Rename) {
+				nw.unwatchTree(event.Name)
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					// A new or moved-in directory needs watches of its own.
+					// Whatever couldn't be watched has to be rescanned.
+					failed, err := nw.addTree(event.Name, true)
+					if err != nil && !errors.Is(err, fs.ErrNotExist) {
+						failed = append(failed, event.Name)
+					}
+					for _, dir := range failed {
+						nw.unwatched = append(nw.unwatched, dir)
+						nw.sendError(&RescanError{Path: dir, Err: ErrUnwatched})
+					}
+					continue
+				}
+			}
+			nw.send(event.Name)
+
+		case err, ok := <-nw.w.Errors:
+			if !ok {
+				return
+			}
+			// The kernel queue overflowed; any event may be missing.
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				err = &RescanError{Path: nw.root, Err: err}
+			}
+			nw.sendError(err)
+
+		case <-retry.C:
+			pending := nw.unwatched
+			nw.unwatched = nil
+			for _, dir := range pending {
+				failed, err := nw.addTree(dir, false)
+				if err != nil && !errors.Is(err, fs.ErrNotExist) {
+					nw.unwatched = append(nw.unwatched, dir)
+				}
+				nw.unwatched = append(nw.unwatched, failed...)
+				// Either way, nobody knows what happened there since the last look.
+				nw.sendError(&RescanError{Path: dir, Err: ErrUnwatched})
+			}
+		}
+	}
+}
+
+func (nw *notifyWatcher) send(path string) {
+	select {
+	case nw.events <- path:
+	case <-nw.done:
+	}
+}
+
+func (nw *notifyWatcher) sendError(err error) {
+	select {
+	case nw.errors <- err:
+	case <-nw.done:
+	}
+}
+
+func (nw *notifyWatcher) Events() <-chan string { return nw.events }
+func (nw *notifyWatcher) Errors() <-chan error  { return nw.errors }
+func (nw *notifyWatcher) Kind() string          { return KindFSNotify }
+
+func (nw *notifyWatcher) Close() error {
+	select {
+	case <-nw.done:
+		return nil
+	default:
+	}
+	close(nw.done)
+	err := nw.w.Close()
+	nw.wg.Wait()
+	return err
+}
@@ -0,0 +1,176 @@
+package watcher
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"git-fs/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// fileState is what the poller compares between walks to spot a change.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+	inode   uint64
+}
+
+// poller walks the tree every interval and reports files that appeared,
+// disappeared or changed size, mtime, mode or inode since the previous walk.
+type poller struct {
+	root     string
+	interval time.Duration
+	files    map[string]fileState
+	// unreadable holds the directories the last walk could not read.
+	unreadable []string
+
+	events chan string
+	errors chan error
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newPoller(root string, interval time.Duration) (*poller, error) {
+	p := &poller{
+		root:     root,
+		interval: interval,
+		events:   make(chan string),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+
+	// The first walk is the baseline; nothing is reported for it.
+	files, unreadable, err := p.walk()
+	if err != nil {
+		return nil, err
+	}
+	p.files = files
+	p.unreadable = unreadable
+
+	p.wg.Add(1)
+	go p.run()
+	return p, nil
+}
+
+func (p *poller) run() {
+	defer p.wg.Done()
+	defer close(p.events)
+	defer close(p.errors)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+}
+
+// poll walks the tree once and sends an event for every difference.
+func (p *poller) poll() {
+	files, unreadable, err := p.walk()
+	if err != nil {
+		p.sendError(err)
+		return
+	}
+
+	for path, state := range files {
+		if old, ok := p.files[path]; !ok || old != state {
+			p.send(path)
+		}
+	}
+	for path, state := range p.files {
+		if _, ok := files[path]; ok {
+			continue
+		}
+		// Files below a directory that couldn't be read are not gone.
+		if under(path, unreadable) {
+			files[path] = state
+			continue
+		}
+		p.send(path)
+	}
+	p.files = files
+	p.unreadable = unreadable
+}
+
+// walk returns the state of every file below the root, and the
+// directories that could not be read.
+func (p *poller) walk() (map[string]fileState, []string, error) {
+	files := make(map[string]fileState)
+	var unreadable []string
+	err := filepath.WalkDir(p.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == p.root {
+				return err
+			}
+			// Only warn when a directory becomes unreadable, not on every walk.
+			if !under(path, p.unreadable) {
+				logging.Logger.Warn("Cannot read directory, keeping its last known state",
+					zap.String("path", path), zap.Error(err))
+			}
+			unreadable = append(unreadable, path)
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed while walking; the next walk reports it.
+			return nil
+		}
+		files[path] = fileState{size: info.Size(), modTime: info.ModTime(), mode: info.Mode(), inode: inode(info)}
+		return nil
+	})
+	return files, unreadable, err
+}
+
+// under reports whether path is one of dirs or inside one of them.
+func under(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *poller) send(path string) {
+	select {
+	case p.events <- path:
+	case <-p.done:
+	}
+}
+
+func (p *poller) sendError(err error) {
+	select {
+	case p.errors <- err:
+	case <-p.done:
+	}
+}
+
+func (p *poller) Events() <-chan string { return p.events }
+func (p *poller) Errors() <-chan error  { return p.errors }
+func (p *poller) Kind() string          { return KindPoll }
+
+func (p *poller) Close() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	close(p.done)
+	p.wg.Wait()
+	return nil
+}
@@ -0,0 +1,85 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"git-fs/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// Kinds of watchers accepted by New.
+const (
+	KindAuto     = "auto"
+	KindFSNotify = "fsnotify"
+	KindPoll     = "poll"
+)
+
+var Kinds = []string{KindAuto, KindFSNotify, KindPoll}
+
+var ErrUnknownKind = errors.New("unknown watcher kind")
+
+// ErrUnwatched is the cause of the periodic rescans of directories that
+// could not be watched.
+var ErrUnwatched = errors.New("directory is not watched")
+
+// RescanError is sent on Errors when events below Path may have been lost,
+// so the tree there has to be compared against the last known state.
+type RescanError struct {
+	Path string
+	Err  error
+}
+
+func (e *RescanError) Error() string {
+	return fmt.Sprintf("events lost below %s: %v", e.Path, e.Err)
+}
+
+func (e *RescanError) Unwrap() error {
+	return e.Err
+}
+
+// Watcher reports changes below a directory tree. Every created, written,
+// removed or renamed path is sent on Events; directories may be reported
+// too, for instance when one is removed together with its contents.
+type Watcher interface {
+	Events() <-chan string
+	Errors() <-chan error
+	// Kind returns the implementation in use, which for KindAuto is only
+	// decided by New.
+	Kind() string
+	Close() error
+}
+
+// New starts a watcher of kind for the tree at root. KindAuto uses fsnotify
+// unless root is on a filesystem that doesn't deliver inotify events, or
+// fsnotify fails to set up, and polls every interval otherwise. fsnotify
+// uses interval to retry directories it could not watch.
+func New(kind, root string, interval time.Duration) (Watcher, error) {
+	logger := logging.Logger
+
+	switch kind {
+	case KindFSNotify:
+		return newNotifyWatcher(root, interval)
+	case KindPoll:
+		return newPoller(root, interval)
+	case KindAuto:
+		if fsType, ok := remoteFS(root); ok {
+			logger.Info("Watch path is on a filesystem without reliable inotify events, polling instead",
+				zap.String("filesystem", fsType),
+				zap.Duration("interval", interval))
+			return newPoller(root, interval)
+		}
+		w, err := newNotifyWatcher(root, interval)
+		if err != nil {
+			logger.Warn("fsnotify is not available for the watch path, polling instead",
+				zap.Duration("interval", interval),
+				zap.Error(err))
+			return newPoller(root, interval)
+		}
+		return w, nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownKind, kind)
+	}
+}
@@ -0,0 +1,162 @@
+package watcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git-fs/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// expectEvent waits for path to be reported by w.
+func expectEvent(t *testing.T, w Watcher, path string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got, ok := <-w.Events():
+			if !ok {
+				t.Fatalf("events closed while waiting for %s", path)
+			}
+			if got == path {
+				return
+			}
+		case err := <-w.Errors():
+			t.Fatalf("unexpected watcher error: %v", err)
+		case <-timeout:
+			t.Fatalf("no event for %s", path)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchers(t *testing.T) {
+	logging.Logger = zap.NewNop()
+
+	for _, kind := range []string{KindFSNotify, KindPoll} {
+		t.Run(kind, func(t *testing.T) {
+			root := t.TempDir()
+			existing := filepath.Join(root, "existing.txt")
+			writeFile(t, existing, "old")
+
+			w, err := New(kind, root, 20*time.Millisecond)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			defer w.Close()
+			if w.Kind() != kind {
+				t.Errorf("Kind() = %s, want %s", w.Kind(), kind)
+			}
+
+			created := filepath.Join(root, "created.txt")
+			writeFile(t, created, "new")
+			expectEvent(t, w, created)
+
+			writeFile(t, existing, "changed")
+			expectEvent(t, w, existing)
+
+			// Files in directories created after the start are seen too.
+			sub := filepath.Join(root, "sub", "deeper")
+			if err := os.MkdirAll(sub, 0755); err != nil {
+				t.Fatal(err)
+			}
+			nested := filepath.Join(sub, "nested.txt")
+			writeFile(t, nested, "nested")
+			expectEvent(t, w, nested)
+
+			if err := os.Remove(created); err != nil {
+				t.Fatal(err)
+			}
+			expectEvent(t, w, created)
+
+			// A renamed directory is watched under its new name.
+			top := filepath.Join(root, "sub", "top.txt")
+			writeFile(t, top, "top")
+			expectEvent(t, w, top)
+			renamed := filepath.Join(root, "renamed")
+			if err := os.Rename(filepath.Join(root, "sub"), renamed); err != nil {
+				t.Fatal(err)
+			}
+			expectEvent(t, w, filepath.Join(renamed, "top.txt"))
+			if err := os.Rename(filepath.Join(renamed, "top.txt"), filepath.Join(root, "top.txt")); err != nil {
+				t.Fatal(err)
+			}
+			expectEvent(t, w, filepath.Join(renamed, "top.txt"))
+
+			if err := w.Close(); err != nil {
+				t.Errorf("Close failed: %v", err)
+			}
+			if _, ok := <-w.Events(); ok {
+				t.Error("events still open after Close")
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	logging.Logger = zap.NewNop()
+
+	t.Run("Unknown kind", func(t *testing.T) {
+		if _, err := New("inotify", t.TempDir(), time.Second); !errors.Is(err, ErrUnknownKind) {
+			t.Errorf("expected ErrUnknownKind, got %v", err)
+		}
+	})
+
+	t.Run("Missing root", func(t *testing.T) {
+		missing := filepath.Join(t.TempDir(), "missing")
+		for _, kind := range Kinds {
+			if w, err := New(kind, missing, time.Second); err == nil {
+				w.Close()
+				t.Errorf("%s: expected an error for a missing root", kind)
+			}
+		}
+	})
+
+	t.Run("Unreadable directory", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can read any directory")
+		}
+		root := t.TempDir()
+		locked := filepath.Join(root, "locked")
+		if err := os.Mkdir(locked, 0); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Chmod(locked, 0755) })
+
+		w, err := New(KindFSNotify, root, 20*time.Millisecond)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer w.Close()
+
+		// The rest of the tree is watched, and the directory rescanned.
+		created := filepath.Join(root, "created.txt")
+		writeFile(t, created, "new")
+		seen, rescanned := false, false
+		timeout := time.After(5 * time.Second)
+		for !seen || !rescanned {
+			select {
+			case got := <-w.Events():
+				seen = seen || got == created
+			case err := <-w.Errors():
+				var rescan *RescanError
+				if !errors.As(err, &rescan) || rescan.Path != locked {
+					t.Fatalf("expected a rescan of %s, got %v", locked, err)
+				}
+				rescanned = true
+			case <-timeout:
+				t.Fatalf("event seen %v, directory rescanned %v", seen, rescanned)
+			}
+		}
+	})
+}